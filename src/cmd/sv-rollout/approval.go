@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"rollout"
+)
+
+// parseApprover builds a rollout.Approver from its specification, as given to
+// `-approve`:
+//
+//	prompt            ask on the terminal
+//	file:/path        wait for the file to be created
+//	socket:/path      wait for "approve" on a UNIX socket
+func parseApprover(spec string) (rollout.Approver, error) {
+	switch {
+	case spec == "prompt":
+		return promptApprover{in: approvalInput, out: os.Stderr}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return fileApprover{path: strings.TrimPrefix(spec, "file:")}, nil
+	case strings.HasPrefix(spec, "socket:"):
+		return socketApprover{path: strings.TrimPrefix(spec, "socket:")}, nil
+	}
+	return nil, fmt.Errorf("unsupported approval gate %q: expected prompt, file:<path> or socket:<path>", spec)
+}
+
+// approved interprets an operator's answer. Anything but a clear yes is a no.
+func approved(answer string) bool {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes", "approve", "continue":
+		return true
+	}
+	return false
+}
+
+// promptApprover asks the operator on the terminal.
+type promptApprover struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (a promptApprover) Approve(ctx context.Context, phase, next string) error {
+	fmt.Fprintf(a.out, "phase %s finished. Continue with phase %s? [y/N] ", phase, next)
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := a.in.ReadString('\n')
+		answer <- line
+	}()
+	select {
+	case line := <-answer:
+		if approved(line) {
+			return nil
+		}
+		return rollout.ErrNotApproved
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fileApprover waits for a file to be created. If it contains "abort" (or
+// anything but a yes), the rollout stops. The file is removed once read, so
+// each phase needs its own approval.
+type fileApprover struct {
+	path string
+}
+
+func (a fileApprover) Approve(ctx context.Context, phase, next string) error {
+	for {
+		b, err := ioutil.ReadFile(a.path)
+		if err == nil {
+			os.Remove(a.path)
+			if len(strings.TrimSpace(string(b))) == 0 || approved(string(b)) {
+				return nil
+			}
+			return rollout.ErrNotApproved
+		}
+		select {
+		case <-time.After(approvalPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// socketApprover listens on a UNIX socket for a line saying "approve" (or
+// "abort"), e.g. from `echo approve | nc -U /path`.
+type socketApprover struct {
+	path string
+}
+
+func (a socketApprover) Approve(ctx context.Context, phase, next string) error {
+	os.Remove(a.path) // left over from an earlier phase or rollout.
+	l, err := net.Listen("unix", a.path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	answers := make(chan bool, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(conn, "phase %s finished, waiting to continue with phase %s\n", phase, next)
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "abort", "no", "n":
+				fmt.Fprintln(conn, "ok, aborting")
+				conn.Close()
+				answers <- false
+				return
+			default:
+				if approved(line) {
+					fmt.Fprintln(conn, "ok, continuing")
+					conn.Close()
+					answers <- true
+					return
+				}
+				fmt.Fprintln(conn, "expected approve or abort")
+				conn.Close()
+			}
+		}
+	}()
+
+	select {
+	case ok := <-answers:
+		if ok {
+			return nil
+		}
+		return rollout.ErrNotApproved
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stubbed in tests
+var (
+	approvalInput        = bufio.NewReader(os.Stdin)
+	approvalPollInterval = time.Second
+)
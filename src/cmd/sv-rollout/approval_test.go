@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestApproval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sv-rollout-approval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Convey("Parsing an approval gate", t, func() {
+		a, err := parseApprover("file:/tmp/go")
+		So(err, ShouldBeNil)
+		So(a, ShouldResemble, fileApprover{path: "/tmp/go"})
+		a, err = parseApprover("socket:/tmp/go.sock")
+		So(err, ShouldBeNil)
+		So(a, ShouldResemble, socketApprover{path: "/tmp/go.sock"})
+		_, err = parseApprover("email:ops@example.com")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Approving on the terminal", t, func() {
+		var out bytes.Buffer
+		ask := func(answer string) error {
+			a := promptApprover{in: bufio.NewReader(strings.NewReader(answer)), out: &out}
+			return a.Approve(context.Background(), "canary", "main")
+		}
+
+		Convey("should continue on yes", func() {
+			So(ask("y\n"), ShouldBeNil)
+			So(out.String(), ShouldEqual, "phase canary finished. Continue with phase main? [y/N] ")
+		})
+
+		Convey("should stop on anything else", func() {
+			So(ask("n\n"), ShouldEqual, rollout.ErrNotApproved)
+			So(ask("\n"), ShouldEqual, rollout.ErrNotApproved)
+			So(ask(""), ShouldEqual, rollout.ErrNotApproved)
+		})
+
+		Convey("should stop waiting when aborted", func() {
+			r, w := io.Pipe()
+			defer w.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			a := promptApprover{in: bufio.NewReader(r), out: &out}
+			So(a.Approve(ctx, "canary", "main"), ShouldEqual, context.Canceled)
+		})
+	})
+
+	Convey("Approving with a file", t, func() {
+		defer func() { approvalPollInterval = time.Second }()
+		approvalPollInterval = 5 * time.Millisecond
+		path := filepath.Join(dir, "approve")
+		a := fileApprover{path: path}
+
+		Convey("should continue once the file is created, and remove it", func() {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				ioutil.WriteFile(path, nil, 0644)
+			}()
+			So(a.Approve(context.Background(), "canary", "main"), ShouldBeNil)
+			_, err := os.Stat(path)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("should stop if the file says abort", func() {
+			ioutil.WriteFile(path, []byte("abort\n"), 0644)
+			So(a.Approve(context.Background(), "canary", "main"), ShouldEqual, rollout.ErrNotApproved)
+		})
+	})
+
+	Convey("Approving on a socket", t, func() {
+		path := filepath.Join(dir, "approve.sock")
+		a := socketApprover{path: path}
+		send := func(line string) string {
+			var conn net.Conn
+			var err error
+			for i := 0; i < 100; i++ {
+				if conn, err = net.Dial("unix", path); err == nil {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if err != nil {
+				return err.Error()
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			r.ReadString('\n')
+			conn.Write([]byte(line))
+			reply, _ := r.ReadString('\n')
+			return reply
+		}
+
+		Convey("should continue on approve", func() {
+			replies := make(chan []string, 1)
+			go func() {
+				replies <- []string{send("hello\n"), send("approve\n")}
+			}()
+			So(a.Approve(context.Background(), "canary", "main"), ShouldBeNil)
+			So(<-replies, ShouldResemble, []string{"expected approve or abort\n", "ok, continuing\n"})
+		})
+
+		Convey("should stop on abort", func() {
+			go send("abort\n")
+			So(a.Approve(context.Background(), "canary", "main"), ShouldEqual, rollout.ErrNotApproved)
+		})
+	})
+}
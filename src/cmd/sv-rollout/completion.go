@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rollout"
+)
+
+// Outcomes of services that didn't restart in this run, as well as the
+// rollout's own result events.
+const (
+	outcomeNotRestarted = "not_restarted"
+	// restarted successfully by the rollout being resumed.
+	outcomeSkipped = "skipped"
+)
+
+// completionStatus names the outcome of a run with the given exit status, as
+// given to completion handlers in $SV_ROLLOUT_STATUS.
+func completionStatus(exit int) string {
+	switch exit {
+	case exitSuccess:
+		return "success"
+	case exitAborted:
+		return "aborted"
+	case exitRolledBack:
+		return "rolled_back"
+	case exitRollbackFailed:
+		return "rollback_failed"
+	default:
+		return "failure"
+	}
+}
+
+// serviceOutcome is what happened to a single service.
+type serviceOutcome struct {
+	Service  string  `json:"service"`
+	Phase    string  `json:"phase,omitempty"`
+	Outcome  string  `json:"outcome"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	// Rollback is the outcome of rolling the service back, if it was.
+	Rollback      string `json:"rollback,omitempty"`
+	RollbackError string `json:"rollback_error,omitempty"`
+}
+
+// rolloutResult is the result file given to completion handlers.
+type rolloutResult struct {
+	Status     string           `json:"status"`
+	ExitStatus int              `json:"exit_status"`
+	Patterns   []string         `json:"patterns"`
+	Excludes   []string         `json:"excludes"`
+	Order      string           `json:"order"`
+	Seed       int64            `json:"seed,omitempty"`
+	StartedAt  time.Time        `json:"started_at"`
+	Duration   float64          `json:"duration"`
+	Counts     rollout.Counts   `json:"counts"`
+	Services   []serviceOutcome `json:"services"`
+}
+
+// Env describes the result in SV_ROLLOUT_* environment variables, with
+// resultFile being the path of the result file.
+func (r rolloutResult) Env(resultFile string) []string {
+	return []string{
+		"SV_ROLLOUT_STATUS=" + r.Status,
+		"SV_ROLLOUT_EXIT_STATUS=" + strconv.Itoa(r.ExitStatus),
+		"SV_ROLLOUT_PATTERN=" + strings.Join(r.Patterns, " "),
+		"SV_ROLLOUT_EXCLUDE=" + strings.Join(r.Excludes, " "),
+		"SV_ROLLOUT_DURATION=" + strconv.FormatFloat(r.Duration, 'f', 1, 64),
+		"SV_ROLLOUT_SERVICES=" + strconv.Itoa(len(r.Services)),
+		"SV_ROLLOUT_SUCCESSES=" + strconv.Itoa(r.Counts.Successes),
+		"SV_ROLLOUT_TIMEOUTS=" + strconv.Itoa(r.Counts.Timeouts),
+		"SV_ROLLOUT_FAILURES=" + strconv.Itoa(r.Counts.Failures),
+		"SV_ROLLOUT_UNSTABLE=" + strconv.Itoa(r.Counts.Unstable),
+		"SV_ROLLOUT_PREEMPTED=" + strconv.Itoa(r.Counts.Preempted),
+		"SV_ROLLOUT_RESULT_FILE=" + resultFile,
+	}
+}
+
+// outcomes collects the outcome of every service in a run from its events.
+type outcomes struct {
+	sync.Mutex
+	services []serviceOutcome
+	index    map[string]int
+}
+
+// newOutcomes starts off every service as not restarted, or skipped if it's
+// in skipped.
+func newOutcomes(services []string, skipped map[string]bool) *outcomes {
+	o := &outcomes{index: make(map[string]int)}
+	for i, svc := range services {
+		outcome := outcomeNotRestarted
+		if skipped[svc] {
+			outcome = outcomeSkipped
+		}
+		o.services = append(o.services, serviceOutcome{Service: svc, Outcome: outcome})
+		o.index[svc] = i
+	}
+	return o
+}
+
+// record notes restart results. It's meant to wrap `report`.
+func (o *outcomes) record(report func(rollout.Event)) func(rollout.Event) {
+	return func(e rollout.Event) {
+		report(e)
+		switch e.Event {
+		case rollout.EventSucceeded, rollout.EventTimedOut, rollout.EventFailed, rollout.EventUnstable, rollout.EventPreempted:
+		default:
+			return
+		}
+		o.Lock()
+		defer o.Unlock()
+		i, ok := o.index[e.Service]
+		if !ok {
+			return
+		}
+		s := &o.services[i]
+		if e.Phase == rollout.RollbackPhase {
+			s.Rollback, s.RollbackError = e.Event, e.Error
+			return
+		}
+		s.Phase, s.Outcome, s.Error, s.Duration = e.Phase, e.Event, e.Error, e.Duration
+	}
+}
+
+// result returns the result of a run started at start that finished with the
+// given exit status.
+func (o *outcomes) result(c config, exit int, start time.Time) rolloutResult {
+	o.Lock()
+	defer o.Unlock()
+	r := rolloutResult{
+		Status:     completionStatus(exit),
+		ExitStatus: exit,
+		Patterns:   c.Patterns,
+		Excludes:   c.Excludes,
+		Order:      c.Order,
+		StartedAt:  start,
+		Duration:   time.Since(start).Seconds(),
+		Services:   append([]serviceOutcome{}, o.services...),
+	}
+	if r.Excludes == nil {
+		r.Excludes = []string{}
+	}
+	if c.Order == orderRandom {
+		r.Seed = c.Seed
+	}
+	for _, s := range o.services {
+		switch s.Outcome {
+		case rollout.EventSucceeded, outcomeSkipped:
+			// services skipped when resuming were restarted successfully
+			// before, as the deployment counts them too.
+			r.Counts.Successes++
+		case rollout.EventTimedOut:
+			r.Counts.Timeouts++
+		case rollout.EventFailed:
+			r.Counts.Failures++
+		case rollout.EventUnstable:
+			r.Counts.Unstable++
+		case rollout.EventPreempted:
+			r.Counts.Preempted++
+		}
+	}
+	return r
+}
+
+// runCompletionHandlers runs `-onsuccess` or `-onfailure`, as appropriate, and
+// then `-oncomplete`, with the result in their environment and in a JSON file
+// that's removed once they're done.
+func runCompletionHandlers(c config, result rolloutResult) {
+	if c.OnSuccess == "" && c.OnFailure == "" && c.OnComplete == "" {
+		return
+	}
+	type handler struct{ name, command string }
+	var handlers []handler
+	if result.ExitStatus == exitSuccess {
+		handlers = append(handlers, handler{"success", c.OnSuccess})
+	} else {
+		handlers = append(handlers, handler{"failure", c.OnFailure})
+	}
+	handlers = append(handlers, handler{"completion", c.OnComplete})
+
+	path, err := writeResultFile(result)
+	if err != nil {
+		log.Println("couldn't write result file:", err)
+	}
+	defer os.Remove(path)
+	env := result.Env(path)
+	for _, h := range handlers {
+		if h.command != "" {
+			runCompletionHandler(h.name, h.command, env)
+		}
+	}
+}
+
+func writeResultFile(result rolloutResult) (string, error) {
+	f, err := ioutil.TempFile("", "sv-rollout-result")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return f.Name(), err
+	}
+	_, err = f.Write(append(b, '\n'))
+	return f.Name(), err
+}
+
+func runCompletionHandler(name, command string, env []string) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("%s handler failed: %s", name, err)
+		return
+	}
+	log.Printf("%s handler: %s", name, command)
+	log.Println(string(output))
+}
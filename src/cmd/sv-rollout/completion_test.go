@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestCompletion(t *testing.T) {
+
+	Convey("Collecting the outcome of each service", t, func() {
+		o := newOutcomes([]string{"a", "b", "c", "d"}, map[string]bool{"d": true})
+		record := o.record(func(rollout.Event) {})
+		record(rollout.Event{Event: rollout.EventRestarting, Service: "a", Phase: "canary"})
+		record(rollout.Event{Event: rollout.EventSucceeded, Service: "a", Phase: "canary", Duration: 1.5})
+		record(rollout.Event{Event: rollout.EventFailed, Service: "b", Phase: "main", Error: "boom"})
+		c := config{Patterns: []string{"svc-*"}}
+
+		Convey("should record each restart's result", func() {
+			r := o.result(c, exitFailure, time.Now())
+			So(r.Status, ShouldEqual, "failure")
+			So(r.Services, ShouldResemble, []serviceOutcome{
+				{Service: "a", Phase: "canary", Outcome: "succeeded", Duration: 1.5},
+				{Service: "b", Phase: "main", Outcome: "failed", Error: "boom"},
+				{Service: "c", Outcome: "not_restarted"},
+				{Service: "d", Outcome: "skipped"},
+			})
+			So(r.Counts, ShouldResemble, rollout.Counts{Successes: 2, Failures: 1})
+			So(r.Excludes, ShouldResemble, []string{})
+		})
+		Convey("should record rollbacks separately", func() {
+			record(rollout.Event{Event: rollout.EventSucceeded, Service: "a", Phase: rollout.RollbackPhase})
+			r := o.result(c, exitRolledBack, time.Now())
+			So(r.Status, ShouldEqual, "rolled_back")
+			So(r.Services[0].Outcome, ShouldEqual, "succeeded")
+			So(r.Services[0].Rollback, ShouldEqual, "succeeded")
+		})
+	})
+
+	Convey("Running completion handlers", t, func() {
+		dir, _ := ioutil.TempDir("", "sv-rollout-completion")
+		defer os.RemoveAll(dir)
+		out := filepath.Join(dir, "out")
+		result := rolloutResult{
+			Status:     "success",
+			ExitStatus: exitSuccess,
+			Patterns:   []string{"svc-*"},
+			Duration:   12.34,
+			Counts:     rollout.Counts{Successes: 2},
+			Services:   []serviceOutcome{{Service: "a", Outcome: "succeeded"}, {Service: "b", Outcome: "succeeded"}},
+		}
+		c := config{
+			OnSuccess:  "echo success $SV_ROLLOUT_STATUS >> " + out,
+			OnFailure:  "echo failure $SV_ROLLOUT_STATUS >> " + out,
+			OnComplete: `echo complete $SV_ROLLOUT_PATTERN $SV_ROLLOUT_SUCCESSES/$SV_ROLLOUT_SERVICES $SV_ROLLOUT_DURATION >> ` + out + `; cp "$SV_ROLLOUT_RESULT_FILE" ` + out + ".json",
+		}
+		lines := func() []string {
+			b, _ := ioutil.ReadFile(out)
+			return strings.Split(strings.TrimSpace(string(b)), "\n")
+		}
+
+		Convey("should run -onsuccess, then -oncomplete, after a success", func() {
+			runCompletionHandlers(c, result)
+			So(lines(), ShouldResemble, []string{"success success", "complete svc-* 2/2 12.3"})
+
+			Convey("with the result in a file", func() {
+				b, _ := ioutil.ReadFile(out + ".json")
+				var r rolloutResult
+				So(json.Unmarshal(b, &r), ShouldBeNil)
+				So(r.Services, ShouldResemble, result.Services)
+			})
+		})
+		Convey("should run -onfailure, then -oncomplete, after a failure", func() {
+			result.Status, result.ExitStatus = "aborted", exitAborted
+			runCompletionHandlers(c, result)
+			So(lines()[0], ShouldEqual, "failure aborted")
+		})
+		Convey("should remove the result file afterwards", func() {
+			c.OnComplete = "echo $SV_ROLLOUT_RESULT_FILE > " + out
+			runCompletionHandlers(c, result)
+			_, err := os.Stat(lines()[0])
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configFile is the structure of the YAML file passed to `-config`. Each
+// profile maps flag names (without the leading dash) to values, so anything
+// that can be passed on the command line can be set in a profile:
+//
+//	profiles:
+//	  jobs:
+//	    pattern: borg-shopify-jobs-*
+//	    timeout: 300
+//	    phases:
+//	      - {name: canary, ratio: 0.1, timeout-tolerance: 0.7}
+//	      - {chunk-ratio: 1, timeout-tolerance: 0.8}
+//
+// Repeatable flags take a list. `phases` is an alias for `phase`, and each
+// phase may be given either as a map or in the `-phase` string form.
+//
+// `services` maps service names or patterns to the settings they override,
+// as in a service's own sv-rollout.conf:
+//
+//	services:
+//	  borg-shopify-jobs-slow-*: {timeout: 900, priority: -1}
+type configFile struct {
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+var profileKeyAliases = map[string]string{
+	"phases": "phase",
+}
+
+// loadConfigFile reads the named profile from a config file and applies it to
+// the flags in fs, returning the settings it overrides for services. Flags
+// given explicitly on the command line take precedence over the file, so fs
+// must already have been parsed.
+func loadConfigFile(fs *flag.FlagSet, path, profile string) (map[string]serviceSettings, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cf configFile
+	if err = yaml.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	settings, ok := cf.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("%s: no such profile %q", path, profile)
+	}
+	var services map[string]serviceSettings
+	if v, ok := settings["services"]; ok {
+		if services, err = profileServiceSettings(v); err != nil {
+			return nil, fmt.Errorf("%s: profile %q: services: %s", path, profile, err)
+		}
+		delete(settings, "services")
+	}
+	if err = applyProfile(fs, settings); err != nil {
+		return nil, fmt.Errorf("%s: profile %q: %s", path, profile, err)
+	}
+	return services, nil
+}
+
+func applyProfile(fs *flag.FlagSet, settings map[string]interface{}) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// sorted, so that errors are reported deterministically.
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := key
+		if alias, ok := profileKeyAliases[key]; ok {
+			name = alias
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if explicit[name] {
+			continue
+		}
+		values, err := profileValues(settings[key])
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// profileValues converts a YAML value into the string(s) to pass to the
+// corresponding flag's Set.
+func profileValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		var values []string
+		for _, elem := range v {
+			s, err := profileValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		s, err := profileValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+}
+
+func profileValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case map[interface{}]interface{}:
+		// key=value pairs, as accepted by `-phase`.
+		var pairs []string
+		for key, value := range v {
+			pairs = append(pairs, fmt.Sprintf("%v=%s", key, scalarValue(value)))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	case []interface{}:
+		return "", fmt.Errorf("unexpected nested list")
+	default:
+		return scalarValue(v), nil
+	}
+}
+
+// scalarValue formats a YAML scalar for a flag. Floats keep their decimal
+// point, so that e.g. `chunk-ratio: 2.0` isn't read as a count.
+func scalarValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	}
+	return fmt.Sprint(v)
+}
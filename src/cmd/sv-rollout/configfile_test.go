@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+const testConfigFile = `
+profiles:
+  jobs:
+    pattern: borg-shopify-jobs-*
+    timeout: 300
+    oncomplete: echo done
+    phases:
+      - {name: canary, ratio: 0.1, timeout-tolerance: 0.7}
+      - name=rest,chunk-ratio=1,timeout-tolerance=0.8
+    services:
+      borg-shopify-jobs-slow-*: {timeout: 900, priority: -1}
+  unicorn:
+    pattern: borg-shopify-unicorn-*
+    chunk-ratio: 0.2
+  broken:
+    no-such-flag: 1
+  brokenservices:
+    services:
+      borg-shopify-jobs-1: {no-such-setting: 1}
+`
+
+func TestConfigFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "sv-rollout-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testConfigFile)
+	f.Close()
+
+	newFlagSet := func(args ...string) (*flag.FlagSet, *string, *int, *float64, *phasesFlag) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var phases phasesFlag
+		pattern := fs.String("pattern", "", "")
+		timeout := fs.Int("timeout", 90, "")
+		chunkRatio := fs.Float64("chunk-ratio", 0.2, "")
+		fs.String("oncomplete", "", "")
+		fs.Var(&phases, "phase", "")
+		fs.Parse(args)
+		return fs, pattern, timeout, chunkRatio, &phases
+	}
+
+	Convey("Loading a profile from a config file", t, func() {
+		Convey("should set flags from the profile", func() {
+			fs, pattern, timeout, _, phases := newFlagSet()
+			services, err := loadConfigFile(fs, f.Name(), "jobs")
+			So(err, ShouldBeNil)
+			So(*pattern, ShouldEqual, "borg-shopify-jobs-*")
+			So(*timeout, ShouldEqual, 300)
+			So(fs.Lookup("oncomplete").Value.String(), ShouldEqual, "echo done")
+			So(*phases, ShouldResemble, phasesFlag{
+				{Name: "canary", Ratio: rollout.Ratio(0.1), ChunkRatio: rollout.Ratio(1), TimeoutTolerance: rollout.Ratio(0.7)},
+				{Name: "rest", ChunkRatio: rollout.Ratio(1), TimeoutTolerance: rollout.Ratio(0.8)},
+			})
+			So(services, ShouldResemble, map[string]serviceSettings{
+				"borg-shopify-jobs-slow-*": {"timeout": "900", "priority": "-1"},
+			})
+		})
+
+		Convey("should let command-line flags override the profile", func() {
+			fs, pattern, timeout, chunkRatio, _ := newFlagSet("-timeout", "600", "-pattern", "borg-shopify-jobs-1")
+			_, err := loadConfigFile(fs, f.Name(), "jobs")
+			So(err, ShouldBeNil)
+			So(*pattern, ShouldEqual, "borg-shopify-jobs-1")
+			So(*timeout, ShouldEqual, 600)
+			So(*chunkRatio, ShouldEqual, 0.2)
+		})
+
+		Convey("should only apply the chosen profile", func() {
+			fs, pattern, timeout, chunkRatio, _ := newFlagSet()
+			services, err := loadConfigFile(fs, f.Name(), "unicorn")
+			So(services, ShouldBeNil)
+			So(err, ShouldBeNil)
+			So(*pattern, ShouldEqual, "borg-shopify-unicorn-*")
+			So(*timeout, ShouldEqual, 90)
+			So(*chunkRatio, ShouldEqual, 0.2)
+		})
+
+		Convey("should fail for a missing profile", func() {
+			fs, _, _, _, _ := newFlagSet()
+			_, err := loadConfigFile(fs, f.Name(), "nope")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail for unknown settings", func() {
+			fs, _, _, _, _ := newFlagSet()
+			_, err := loadConfigFile(fs, f.Name(), "broken")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail for unknown service settings", func() {
+			fs, _, _, _, _ := newFlagSet()
+			_, err := loadConfigFile(fs, f.Name(), "brokenservices")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail for a missing file", func() {
+			fs, _, _, _, _ := newFlagSet()
+			_, err := loadConfigFile(fs, f.Name()+".missing", "jobs")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should keep whole-number floats from reading as counts", func() {
+			So(scalarValue(2.0), ShouldEqual, "2.0")
+			So(scalarValue(0.25), ShouldEqual, "0.25")
+			So(scalarValue(3), ShouldEqual, "3")
+			v, err := profileValue(map[interface{}]interface{}{"ratio": 1.0, "chunk-ratio": "20%:2..10"})
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "chunk-ratio=20%:2..10,ratio=1.0")
+		})
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"rollout"
+)
+
+var (
+	stdoutLogger = log.New(os.Stdout, "", log.LstdFlags)
+	stderrLogger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// reportText prints service events in the traditional
+// "[index/n] (service) message" format. Rollout and phase events are only
+// printed in verbose mode.
+func reportText(e rollout.Event) {
+	var message string
+	toStderr := false
+	switch e.Event {
+	case rollout.EventRestarting:
+		message = "restarting"
+	case rollout.EventSucceeded:
+		message = "successfully restarted"
+	case rollout.EventTimedOut:
+		message, toStderr = "did not restart in time", true
+	case rollout.EventFailed:
+		message, toStderr = "failed to restart", true
+	case rollout.EventUnstable:
+		message, toStderr = "did not stay up after restarting", true
+	case rollout.EventPreempted:
+		message, toStderr = "was not required to restart in time", true
+	case rollout.EventRollbackStarted, rollout.EventRollbackFinished, rollout.EventBakeFailed, rollout.EventAwaitingApproval:
+		log.Print(describeEvent(e))
+		return
+	default:
+		if Verbose {
+			log.Printf("[debug] %s", describeEvent(e))
+		}
+		return
+	}
+
+	logFunc := stdoutLog
+	if toStderr {
+		logFunc = stderrLog
+	}
+	logFunc(fmt.Sprintf("[%d/%d] (%s) %s", e.Index, e.Services, e.Service, message))
+}
+
+func describeEvent(e rollout.Event) string {
+	switch e.Event {
+	case rollout.EventRolloutStarted:
+		return fmt.Sprintf("rollout of %d services started", e.Services)
+	case rollout.EventPhaseStarted:
+		return fmt.Sprintf("phase %s started with %d services", e.Phase, e.PhaseServices)
+	case rollout.EventRolloutFinished:
+		msg := fmt.Sprintf("rollout finished after %.1fs", e.Duration)
+		if e.Error != "" {
+			msg += ": " + e.Error
+		}
+		return msg
+	case rollout.EventBakeStarted:
+		return fmt.Sprintf("phase %s baking for %.0fs, re-checking %d services", e.Phase, e.Duration, e.PhaseServices)
+	case rollout.EventBakeFailed:
+		return fmt.Sprintf("phase %s failed to bake: %s", e.Phase, e.Error)
+	case rollout.EventAwaitingApproval:
+		return fmt.Sprintf("phase %s finished, waiting for approval to continue", e.Phase)
+	case rollout.EventApproved:
+		return fmt.Sprintf("phase %s approved", e.Phase)
+	case rollout.EventRollbackStarted:
+		return fmt.Sprintf("rolling back %d services", e.Services)
+	case rollout.EventRollbackFinished:
+		msg := fmt.Sprintf("rollback finished after %.1fs: %d of %d services rolled back", e.Duration, e.Counts.Successes, e.Services)
+		if e.Error != "" {
+			msg += ": " + e.Error
+		}
+		return msg
+	}
+	return e.Event
+}
+
+var jsonReportMutex sync.Mutex
+
+// reportJSON prints each event as a single line of JSON.
+func reportJSON(e rollout.Event) {
+	jsonReportMutex.Lock()
+	defer jsonReportMutex.Unlock()
+	json.NewEncoder(jsonReportOutput).Encode(e)
+}
+
+var jsonReportOutput io.Writer = os.Stdout
+
+// report is where every Event goes. It's set by the `-output` CLI flag.
+var report = reportText
+
+// test stubs
+var (
+	stdoutLog = stdoutLogger.Println
+	stderrLog = stderrLogger.Println
+)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestEvents(t *testing.T) {
+
+	Convey("Reporting events as text", t, func() {
+		var outLogs []string
+		var errLogs []string
+		stdoutLog = func(a ...interface{}) { outLogs = append(outLogs, a[0].(string)) }
+		stderrLog = func(a ...interface{}) { errLogs = append(errLogs, a[0].(string)) }
+
+		reportText(rollout.Event{Event: rollout.EventRolloutStarted, Services: 3})
+		reportText(rollout.Event{Event: rollout.EventRestarting, Service: "a", Index: 1, Services: 3})
+		reportText(rollout.Event{Event: rollout.EventSucceeded, Service: "a", Index: 1, Services: 3})
+		reportText(rollout.Event{Event: rollout.EventFailed, Service: "b", Index: 2, Services: 3, Error: "boom"})
+
+		Convey("should only print service events, in the usual format", func() {
+			So(outLogs, ShouldResemble, []string{
+				"[1/3] (a) restarting",
+				"[1/3] (a) successfully restarted",
+			})
+			So(errLogs, ShouldResemble, []string{
+				"[2/3] (b) failed to restart",
+			})
+		})
+	})
+
+	Convey("Reporting events as JSON", t, func() {
+		var buf bytes.Buffer
+		jsonReportOutput = &buf
+		defer func() { jsonReportOutput = os.Stdout }()
+
+		now := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+		reportJSON(rollout.Event{Time: now, Event: rollout.EventTimedOut, Phase: "canary", Service: "a", Index: 1, Services: 3, Duration: 1.5, Counts: &rollout.Counts{Timeouts: 1}, Error: "restart timed out for service 'a'"})
+		reportJSON(rollout.Event{Time: now, Event: rollout.EventRolloutFinished, Services: 3})
+
+		Convey("should print one object per line", func() {
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			So(len(lines), ShouldEqual, 2)
+			So(lines[0], ShouldEqual, `{"time":"2016-06-01T12:00:00Z","event":"timed_out","phase":"canary","service":"a","index":1,"services":3,"duration":1.5,"counts":{"successes":0,"timeouts":1,"failures":0,"unstable":0,"preempted":0},"error":"restart timed out for service 'a'"}`)
+			So(lines[1], ShouldEqual, `{"time":"2016-06-01T12:00:00Z","event":"rollout_finished","services":3}`)
+		})
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"rollout"
+)
+
+// journalHeader is the first line of a state file, identifying the rollout it
+// records.
+type journalHeader struct {
+	Patterns   []string  `json:"patterns"`
+	Excludes   []string  `json:"excludes"`
+	ConfigHash string    `json:"config_hash"`
+	StartedAt  time.Time `json:"started_at"`
+	// Services, in the order they're restarted, so that a resumed rollout
+	// assigns them to the same phases.
+	Services []string `json:"services"`
+}
+
+// journalEntry records the outcome of a single restart. Each is written on a
+// line of its own, after the header.
+type journalEntry struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Phase   string    `json:"phase"`
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// journal appends the outcome of every restart to a state file as it
+// happens, so that an interrupted rollout can be resumed with `-resume`.
+type journal struct {
+	sync.Mutex
+	f *os.File
+}
+
+// createJournal starts a new state file for a rollout, replacing any old one.
+func createJournal(path string, header journalHeader) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{f: f}
+	if err = j.write(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// appendJournal reopens an existing state file to record a resumed rollout.
+func appendJournal(path string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f}, nil
+}
+
+func (j *journal) write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	j.Lock()
+	defer j.Unlock()
+	if _, err = j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// record journals restart results. It's meant to wrap `report`.
+func (j *journal) record(report func(rollout.Event)) func(rollout.Event) {
+	return func(e rollout.Event) {
+		report(e)
+		if e.Phase == rollout.RollbackPhase {
+			// a rolled-back service needs restarting again if the rollout is
+			// resumed.
+			return
+		}
+		switch e.Event {
+		case rollout.EventSucceeded, rollout.EventTimedOut, rollout.EventFailed, rollout.EventUnstable, rollout.EventPreempted:
+			err := j.write(journalEntry{
+				Time:    e.Time,
+				Service: e.Service,
+				Phase:   e.Phase,
+				Outcome: e.Event,
+				Error:   e.Error,
+			})
+			if err != nil {
+				stderrLog(fmt.Sprintf("couldn't write to state file: %s", err))
+			}
+		}
+	}
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+// journalState is what a state file says about a previous rollout.
+type journalState struct {
+	Header journalHeader
+	// Succeeded holds the services that were restarted successfully.
+	Succeeded map[string]bool
+}
+
+func loadJournal(path string) (state journalState, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return state, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return state, fmt.Errorf("%s: empty state file", path)
+	}
+	if err = json.Unmarshal(scanner.Bytes(), &state.Header); err != nil {
+		return state, fmt.Errorf("%s: %s", path, err)
+	}
+	state.Succeeded = make(map[string]bool)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// most likely a partial line written as we crashed.
+			continue
+		}
+		// a later attempt at the same service supersedes an earlier one.
+		state.Succeeded[entry.Service] = entry.Outcome == rollout.EventSucceeded
+	}
+	return state, scanner.Err()
+}
+
+// resumeOrder returns the services to restart when resuming: those from the
+// previous rollout that still match, in their original order, followed by any
+// that match now but didn't then.
+func (st journalState) resumeOrder(services []string) []string {
+	current := make(map[string]bool)
+	for _, svc := range services {
+		current[svc] = true
+	}
+	var ordered []string
+	seen := make(map[string]bool)
+	for _, svc := range st.Header.Services {
+		if current[svc] {
+			ordered = append(ordered, svc)
+			seen[svc] = true
+		}
+	}
+	for _, svc := range services {
+		if !seen[svc] {
+			ordered = append(ordered, svc)
+		}
+	}
+	return ordered
+}
+
+// Hash identifies the settings that determine which services a rollout
+// restarts and how, so that a state file isn't used to resume a different
+// rollout.
+func (c config) Hash() string {
+	b, _ := json.Marshal(struct {
+		Patterns []string
+		Excludes []string
+		Svdir    string
+		Phases   []rollout.Phase
+		Timeout  int
+	}{c.Patterns, c.Excludes, c.Svdir, c.Phases(), c.Timeout})
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
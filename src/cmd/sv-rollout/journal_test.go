@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sv-rollout-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+
+	Convey("A state file", t, func() {
+		started := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+		j, err := createJournal(path, journalHeader{
+			Patterns:   []string{"borg-*"},
+			ConfigHash: "abc",
+			StartedAt:  started,
+			Services:   []string{"c", "a", "b"},
+		})
+		So(err, ShouldBeNil)
+
+		var reported []rollout.Event
+		record := j.record(func(e rollout.Event) { reported = append(reported, e) })
+		record(rollout.Event{Event: rollout.EventRolloutStarted})
+		record(rollout.Event{Event: rollout.EventSucceeded, Service: "c", Phase: "canary"})
+		record(rollout.Event{Event: rollout.EventFailed, Service: "a", Phase: "main", Error: "down"})
+		So(j.Close(), ShouldBeNil)
+
+		Convey("should pass events on", func() {
+			So(len(reported), ShouldEqual, 3)
+		})
+
+		Convey("should record the rollout and each restart's outcome", func() {
+			state, err := loadJournal(path)
+			So(err, ShouldBeNil)
+			So(state.Header.ConfigHash, ShouldEqual, "abc")
+			So(state.Header.StartedAt.Equal(started), ShouldBeTrue)
+			So(state.Succeeded, ShouldResemble, map[string]bool{"c": true, "a": false})
+		})
+
+		Convey("should let a resumed rollout supersede earlier outcomes", func() {
+			j, err := appendJournal(path)
+			So(err, ShouldBeNil)
+			j.record(func(rollout.Event) {})(rollout.Event{Event: rollout.EventSucceeded, Service: "a"})
+			j.Close()
+			state, err := loadJournal(path)
+			So(err, ShouldBeNil)
+			So(state.Succeeded["a"], ShouldBeTrue)
+			So(state.Header.Services, ShouldResemble, []string{"c", "a", "b"})
+		})
+
+		Convey("should ignore a partially written line", func() {
+			f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			f.WriteString(`{"service":"b","outc`)
+			f.Close()
+			state, err := loadJournal(path)
+			So(err, ShouldBeNil)
+			So(state.Succeeded["b"], ShouldBeFalse)
+		})
+
+		Convey("should resume in the original order, with new services last", func() {
+			state, _ := loadJournal(path)
+			So(state.resumeOrder([]string{"d", "b", "c"}), ShouldResemble, []string{"c", "b", "d"})
+		})
+	})
+
+	Convey("A config's hash", t, func() {
+		c := config{Patterns: []string{"borg-*"}, CanaryRatio: rollout.Ratio(0.1), ChunkRatio: rollout.Ratio(0.2), Timeout: 90}
+
+		Convey("should change with the services and phases", func() {
+			other := c
+			other.ChunkRatio = rollout.Ratio(0.3)
+			So(other.Hash(), ShouldNotEqual, c.Hash())
+			other = c
+			other.Patterns = []string{"borg-shopify-*"}
+			So(other.Hash(), ShouldNotEqual, c.Hash())
+		})
+
+		Convey("should not change with unrelated settings", func() {
+			other := c
+			other.Output = "json"
+			other.StateFile = "/tmp/state"
+			So(other.Hash(), ShouldEqual, c.Hash())
+		})
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const defaultLockDir = "/var/lock"
+
+// lockHolder is written into a lock file by the rollout holding it, so that a
+// rollout that can't get the lock can say who has it.
+type lockHolder struct {
+	Pid       int       `json:"pid"`
+	Patterns  []string  `json:"patterns"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// errLocked means that another rollout held a lock for longer than we were
+// prepared to wait.
+type errLocked struct {
+	Path   string
+	Holder lockHolder
+}
+
+func (e errLocked) Error() string {
+	if e.Holder.Pid == 0 {
+		return fmt.Sprintf("%s is locked by another sv-rollout", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by another sv-rollout (pid %d, patterns %s, started %s)",
+		e.Path, e.Holder.Pid, strings.Join(e.Holder.Patterns, " "), e.Holder.StartedAt.Format(time.RFC3339))
+}
+
+// lockPaths returns the lock files a rollout of services must hold, in the
+// order to take them: one per service with `-lock-scope services`, so that
+// rollouts of different services in the same directory can run at once, or
+// one for the service directory with `-lock-scope svdir`.
+func lockPaths(c config, services []string) []string {
+	prefix := filepath.Join(c.LockDir, "sv-rollout."+lockName(c.Svdir))
+	switch c.LockScope {
+	case "svdir":
+		return []string{prefix + ".lock"}
+	case "services":
+		sorted := append([]string{}, services...)
+		// always in the same order, so that rollouts of overlapping sets of
+		// services can't each hold a lock the other is waiting for.
+		sort.Strings(sorted)
+		paths := make([]string, len(sorted))
+		for i, svc := range sorted {
+			paths[i] = prefix + "." + svc + ".lock"
+		}
+		return paths
+	}
+	return nil
+}
+
+// lockName turns the path of a directory into something usable in a file
+// name, e.g. "/etc/service" becomes "etc-service".
+func lockName(dir string) string {
+	name := strings.Replace(strings.Trim(filepath.Clean(dir), "/"), "/", "-", -1)
+	if name == "" || name == "." {
+		return "root"
+	}
+	return name
+}
+
+// rolloutLock is the set of lock files held by a rollout.
+type rolloutLock struct {
+	files []*os.File
+}
+
+// acquireLock takes an exclusive flock on each of paths in turn, creating
+// them if need be, and writes holder into each. If a lock is held by another
+// rollout, it waits up to wait for it to be released (counting from when it
+// started acquiring), then gives up with errLocked, releasing any it did get.
+func acquireLock(paths []string, holder lockHolder, wait time.Duration) (*rolloutLock, error) {
+	l := &rolloutLock{}
+	deadline := time.Now().Add(wait)
+	for _, path := range paths {
+		f, err := lockFile(path, deadline)
+		if err != nil {
+			l.Release()
+			return nil, err
+		}
+		l.files = append(l.files, f)
+		if err = writeLockHolder(f, holder); err != nil {
+			l.Release()
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return l, nil
+}
+
+func lockFile(path string, deadline time.Time) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	waiting := false
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		locked := errLocked{Path: path, Holder: readLockHolder(f)}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, locked
+		}
+		if !waiting {
+			log.Printf("%s, waiting for it to be released", locked)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+func writeLockHolder(f *os.File, holder lockHolder) error {
+	b, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	if err = f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(append(b, '\n'), 0)
+	return err
+}
+
+// readLockHolder says who holds the lock on f, as far as it can tell.
+func readLockHolder(f *os.File) (holder lockHolder) {
+	b, err := ioutil.ReadFile(f.Name())
+	if err == nil {
+		json.Unmarshal(b, &holder)
+	}
+	return
+}
+
+// Release gives up the locks. The lock files are left in place: removing one
+// could let two rollouts lock different files at the same path.
+func (l *rolloutLock) Release() {
+	for _, f := range l.files {
+		f.Close()
+	}
+	l.files = nil
+}
+
+// stubbed in tests
+var lockPollInterval = 250 * time.Millisecond
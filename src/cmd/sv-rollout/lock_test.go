@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLock(t *testing.T) {
+
+	Convey("Choosing lock files", t, func() {
+		c := config{Svdir: "/etc/service", LockDir: "/var/lock"}
+
+		Convey("should lock the whole service directory by default", func() {
+			c.LockScope = "svdir"
+			So(lockPaths(c, []string{"b", "a"}), ShouldResemble, []string{"/var/lock/sv-rollout.etc-service.lock"})
+		})
+		Convey("should lock each service, in order, if asked to", func() {
+			c.LockScope = "services"
+			So(lockPaths(c, []string{"b", "a"}), ShouldResemble, []string{
+				"/var/lock/sv-rollout.etc-service.a.lock",
+				"/var/lock/sv-rollout.etc-service.b.lock",
+			})
+		})
+		Convey("should lock nothing if asked not to", func() {
+			c.LockScope = "none"
+			So(lockPaths(c, []string{"a"}), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Acquiring a lock", t, func() {
+		dir, _ := ioutil.TempDir("", "sv-rollout-lock")
+		defer os.RemoveAll(dir)
+		lockPollInterval = 10 * time.Millisecond
+		a, b, c := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")
+		started := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+		holder := lockHolder{Pid: 1234, Patterns: []string{"borg-*"}, StartedAt: started}
+
+		first, err := acquireLock([]string{a, b}, holder, 0)
+		So(err, ShouldBeNil)
+		defer first.Release()
+
+		Convey("should record who holds it", func() {
+			contents, _ := ioutil.ReadFile(a)
+			So(string(contents), ShouldEqual, `{"pid":1234,"patterns":["borg-*"],"started_at":"2016-06-01T12:00:00Z"}`+"\n")
+		})
+		Convey("should fail straight away, saying who holds it, if another rollout does", func() {
+			_, err := acquireLock([]string{c, b}, lockHolder{Pid: 5678}, 0)
+			So(err, ShouldResemble, errLocked{Path: b, Holder: holder})
+			So(err.Error(), ShouldEqual, b+" is locked by another sv-rollout (pid 1234, patterns borg-*, started 2016-06-01T12:00:00Z)")
+
+			Convey("and give up any it did get", func() {
+				other, err := acquireLock([]string{c}, holder, 0)
+				So(err, ShouldBeNil)
+				other.Release()
+			})
+		})
+		Convey("should succeed for a different lock", func() {
+			other, err := acquireLock([]string{c}, holder, 0)
+			So(err, ShouldBeNil)
+			other.Release()
+		})
+		Convey("should wait for the other rollout to release it", func() {
+			released := make(chan struct{})
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				first.Release()
+				close(released)
+			}()
+			second, err := acquireLock([]string{a}, lockHolder{Pid: 5678}, time.Second)
+			<-released
+			So(err, ShouldBeNil)
+			second.Release()
+		})
+		Convey("should give up waiting eventually", func() {
+			_, err := acquireLock([]string{a}, lockHolder{Pid: 5678}, 50*time.Millisecond)
+			So(err, ShouldHaveSameTypeAs, errLocked{})
+		})
+
+		Reset(func() {
+			lockPollInterval = 250 * time.Millisecond
+		})
+	})
+}
@@ -5,29 +5,87 @@ supports canaries and has configurable tolerance for timeouts.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/Shopify/go-dogstatsd"
 	"log"
-	"math/rand"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"syscall"
 	"time"
+
+	"rollout"
+)
+
+const (
+	defaultSvdir = rollout.DefaultSvdir
 )
 
+// Exit statuses. 2 is used by the flag package for usage errors.
 const (
-	svdir = "/etc/service"
+	exitSuccess = 0
+	exitFailure = 1
+	exitAborted = 3
+	// the rollout failed, but every service it restarted was rolled back.
+	exitRolledBack     = 4
+	exitRollbackFailed = 5
 )
 
 type config struct {
-	CanaryRatio            float64
-	CanaryTimeoutTolerance float64
-	ChunkRatio             float64
-	TimeoutTolerance       float64
+	Patterns               []string
+	Excludes               []string
+	CanaryRatio            rollout.Quantity
+	CanaryTimeoutTolerance rollout.Quantity
+	ChunkRatio             rollout.Quantity
+	TimeoutTolerance       rollout.Quantity
+	CanaryFailureTolerance rollout.Quantity
+	FailureTolerance       rollout.Quantity
+	CanaryFailureCount     int
+	FailureCount           int
 	Timeout                int
 	OnComplete             string
+	OnSuccess              string
+	OnFailure              string
+	ExplicitPhases         []rollout.Phase
+	DryRun                 bool
+	Output                 string
+	HealthCheck            *rollout.HealthCheck
+	HealthCheckStatus      int
+	HealthCheckTimeout     time.Duration
+	StableFor              time.Duration
+	Svdir                  string
+	StateFile              string
+	Resume                 bool
+	Rollback               string
+	MaxStartsPerMinute     float64
+	StartBurst             int
+	MinStartInterval       time.Duration
+	Bake                   time.Duration
+	Approver               rollout.Approver
+	PreRestart             string
+	PostRestart            string
+	HookTimeout            time.Duration
+	HookFailure            string
+	LockScope              string
+	LockDir                string
+	LockWait               time.Duration
+	MetricsTextfileDir     string
+	MetricsListen          string
+	MetricsName            string
+	ReportJSON             string
+	ReportJUnit            string
+	Order                  string
+	OrderFile              string
+	Seed                   int64
+	Canaries               []string
+	// ServiceSettings are the settings overridden by the config file, by
+	// service name or pattern.
+	ServiceSettings map[string]serviceSettings
+	// Overrides are resolved for each service once the services are known.
+	Overrides map[string]rollout.Overrides
 }
 
 func init() {
@@ -38,13 +96,39 @@ func init() {
 // It's set by the `-verbose` CLI flag.
 var Verbose bool
 
-func (c config) AssertValid(pattern string) {
+func (c config) AssertValid() {
 	msg := ""
-	if pattern == "" {
+	if len(c.Patterns) == 0 {
 		msg = "-pattern must be provided"
 	}
-	if c.ChunkRatio < c.CanaryRatio {
-		msg = "-chunk-ratio must be >= -canary-ratio. This is not an inherent limitation, feel free to add code to handle this case."
+	for _, pattern := range append(append([]string{}, c.Patterns...), c.Excludes...) {
+		if _, err := newServiceMatcher(pattern); err != nil {
+			msg = fmt.Sprintf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	if c.Output != "text" && c.Output != "json" {
+		msg = "-output must be one of: text, json"
+	}
+	if c.MaxStartsPerMinute < 0 || c.MinStartInterval < 0 {
+		msg = "-max-starts-per-minute and -min-start-interval must not be negative"
+	}
+	if c.HookFailure != "fail" && c.HookFailure != "ignore" {
+		msg = "-hook-failure must be one of: fail, ignore"
+	}
+	if c.LockScope != "svdir" && c.LockScope != "services" && c.LockScope != "none" {
+		msg = "-lock-scope must be one of: services, svdir, none"
+	}
+	switch c.Order {
+	case orderRandom, orderSorted, orderReverse:
+	case orderFile:
+		if c.OrderFile == "" {
+			msg = "-order file requires -order-file"
+		}
+	default:
+		msg = "-order must be one of: random, sorted, reverse, file"
+	}
+	if c.Resume && c.StateFile == "" {
+		msg = "-resume requires -state-file"
 	}
 	if msg != "" {
 		fmt.Println(msg)
@@ -53,18 +137,53 @@ func (c config) AssertValid(pattern string) {
 	}
 }
 
+// Options returns the options to restart services with. Events go to `report`.
+func (c config) Options() rollout.Options {
+	opts := rollout.Options{
+		Phases:             c.Phases(),
+		Svdir:              c.Svdir,
+		Timeout:            time.Duration(c.Timeout) * time.Second,
+		HealthCheck:        c.HealthCheck,
+		StableFor:          c.StableFor,
+		Bake:               c.Bake,
+		Approver:           c.Approver,
+		MaxStartsPerMinute: c.MaxStartsPerMinute,
+		StartBurst:         c.StartBurst,
+		MinStartInterval:   c.MinStartInterval,
+		OnEvent:            func(e rollout.Event) { report(e) },
+		Verbose:            Verbose,
+		IgnoreHookFailures: c.HookFailure == "ignore",
+		Overrides:          c.Overrides,
+		Canaries:           c.Canaries,
+	}
+	if Statsd != nil {
+		opts.Statsd = Statsd
+	}
+	if c.PreRestart != "" {
+		opts.PreRestart = rollout.CommandHook{Command: c.PreRestart, Timeout: c.HookTimeout}
+	}
+	if c.PostRestart != "" {
+		opts.PostRestart = rollout.CommandHook{Command: c.PostRestart, Timeout: c.HookTimeout}
+	}
+	return opts
+}
+
 var (
 	// Statsd is a globally-shared datadog client.
 	Statsd *dogstatsd.Client
 )
 
-func configureStatsd() {
+// configureStatsd connects Statsd to the dogstatsd agent at addr, prefixing
+// every metric with namespace and tagging it with tags. An empty addr leaves
+// statsd off.
+func configureStatsd(addr, namespace string, tags []string) {
 	var err error
-	if Statsd != nil {
+	if Statsd != nil || addr == "" {
 		return
 	}
-	Statsd, err = dogstatsd.New("127.0.0.1:8125", &dogstatsd.Context{
-		Namespace: "sv-rollout.",
+	Statsd, err = dogstatsd.New(addr, &dogstatsd.Context{
+		Namespace: namespace,
+		Tags:      tags,
 	})
 	if err != nil {
 		log.Println("Can't connect to statsd: ", err)
@@ -72,100 +191,477 @@ func configureStatsd() {
 }
 
 func init() {
-	rand.Seed(time.Now().UnixNano()) // init(), not main(), so that tests get it too.
-
 	oldUsage := flag.Usage
 	flag.Usage = func() {
 		oldUsage()
+		fmt.Fprintln(os.Stderr, "Ratios and tolerances may also be given as a percentage (\"25%\") or a count (\"3x\"), and clamped with \":min..max\" (\"20%:2..10\").")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintln(os.Stderr, "  # Restart one service first. Restart everything else once it succeeds. No timeouts allowed, wait up to 5 minutes for restarts.")
 		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -canary-ratio 0.0001 -chunk-ratio 1 -timeout 300 -pattern 'borg-*'")
 		fmt.Fprintf(os.Stderr, "%s", "  # Restart 10% of services first, allowing up to 50% of those to time out. Then, restart all other services, 30% at a time, allowing up to 70% to time out.\n")
 		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -canary-ratio 0.1 -chunk-ratio 0.3 -canary-timeout-tolerance 0.5 -timeout-tolerance 0.7 -timeout 300 -pattern 'borg-*'")
+		fmt.Fprintln(os.Stderr, "  # Restart one service first, then the rest 20% at a time, tolerating up to 3 (or 1%, if more) of them failing.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -canary-ratio 1x -chunk-ratio 0.2 -failure-tolerance 0.01 -failure-count 3 -pattern 'borg-*'")
+		fmt.Fprintln(os.Stderr, "  # Restart two services first, then the rest 20% at a time, but at least 2 and at most 10 at a time.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -canary-ratio 2 -chunk-ratio 20%:2..10 -pattern 'borg-*'")
+		fmt.Fprintln(os.Stderr, "  # Restart one service, then 5% of services, then 25%, then the rest 20% at a time, allowing 10% of the last two phases to time out.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -phase ratio=1x -phase ratio=0.05 -phase ratio=0.25,timeout-tolerance=0.1 -phase chunk-ratio=0.2,timeout-tolerance=0.1 -timeout 300 -pattern 'borg-*'")
+		fmt.Fprintln(os.Stderr, "  # Restart all borg-shopify services except the cron ones, one at a time.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -canary-ratio 0 -chunk-ratio 1x -pattern 'borg-shopify-*' -exclude 'borg-shopify-*-cron*'")
+		fmt.Fprintln(os.Stderr, "  # Use the settings from the 'jobs' profile in a config file, but with a longer timeout.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -config /etc/sv-rollout.yml -profile jobs -timeout 600")
+		fmt.Fprintln(os.Stderr, "  # Show which services would be restarted in which phase, without restarting anything.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -config /etc/sv-rollout.yml -profile jobs -dry-run -output json")
+		fmt.Fprintln(os.Stderr, "  # Restart 10 services at a time, but start no more than 30 a minute, and at least 1 second apart, to spare the database.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -canary-ratio 0 -chunk-ratio 10 -max-starts-per-minute 30 -min-start-interval 1 -pattern 'borg-shopify-*'")
+		fmt.Fprintln(os.Stderr, "  # Restart 5% of services, check they stay healthy for 10 minutes, then wait for the go-ahead before restarting the rest.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -canary-ratio 5% -bake 600 -approve prompt -health-check http://127.0.0.1:8080/status -pattern 'borg-shopify-*'")
+		fmt.Fprintln(os.Stderr, "  # If too many services fail, point the ones already restarted back at the previous release and restart them again.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -pattern 'borg-shopify-*' -rollback 'ln -sfn previous /app/{service}/current'")
+		fmt.Fprintln(os.Stderr, "  # Take each service out of the load balancer while it restarts, and put it back afterwards.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -pattern 'borg-shopify-*' -pre-restart 'lb-ctl drain {service}' -post-restart 'lb-ctl enable {service}' -hook-timeout 30")
+		fmt.Fprintln(os.Stderr, "  # Restart services one at a time, waiting up to 10 minutes for any other rollout of the same services to finish first.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -canary-ratio 0 -chunk-ratio 1x -pattern 'borg-shopify-*' -lock-wait 10m")
+		fmt.Fprintln(os.Stderr, "  # Export metrics about the rollout to Prometheus, both while it runs and, via node_exporter, afterwards.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -config /etc/sv-rollout.yml -profile jobs -metrics-listen :9199 -metrics-textfile-dir /var/lib/node_exporter/textfile")
+		fmt.Fprintln(os.Stderr, "  # Record each service's outcome as a JUnit test case, for CI to display.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -config /etc/sv-rollout.yml -profile jobs -report-junit sv-rollout.xml")
+		fmt.Fprintln(os.Stderr, "  # Always try a known-good service first, then restart the rest in the same order as an earlier rollout.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -pattern 'borg-shopify-*' -canary borg-shopify-7 -seed 1464782400")
+		fmt.Fprintln(os.Stderr, "  # Record progress so that, if interrupted, running the same command again picks up where it left off.")
+		fmt.Fprintln(os.Stderr, "  "+os.Args[0]+" -config /etc/sv-rollout.yml -profile jobs -state-file /var/run/sv-rollout-jobs.state -resume")
 	}
 }
 
 func main() {
-	configureStatsd()
 	var (
-		canaryRatio            = flag.Float64("canary-ratio", 0.001, "canary nodes are restarted first. If they fail, the deploy is failed. Rounded up to the nearest node, unless set to zero")
-		canaryTimeoutTolerance = flag.Float64("canary-timeout-tolerance", 0, "ratio of canary nodes that are permitted to time out without causing the deploy to fail")
-		chunkRatio             = flag.Float64("chunk-ratio", 0.2, "after canary nodes, ratio of remaining nodes permitted to restart concurrently")
-		timeoutTolerance       = flag.Float64("timeout-tolerance", 0, "ratio of total nodes whose restarts may time out and still consider the deploy a success")
+		canaryRatio            = rollout.Ratio(0.001)
+		canaryTimeoutTolerance rollout.Quantity
+		chunkRatio             = rollout.Ratio(0.2)
+		timeoutTolerance       rollout.Quantity
+		canaryFailureTolerance rollout.Quantity
+		failureTolerance       rollout.Quantity
+		canaryFailureCount     = flag.Int("canary-failure-count", 0, "number of canary nodes permitted to fail, if more than -canary-failure-tolerance permits")
+		failureCount           = flag.Int("failure-count", 0, "number of non-canary nodes permitted to fail, if more than -failure-tolerance permits")
 		timeout                = flag.Int("timeout", 90, "number of seconds to wait for a service to restart before considering it timed out and moving on")
-		pattern                = flag.String("pattern", "", "(required) glob pattern to match /etc/service entries (e.g. \"borg-shopify-*\")")
-		onComplete             = flag.String("oncomplete", "", "command to execute when the deploy finishes (regardless of success)")
+		patterns               stringsFlag
+		statsdTags             stringsFlag
+		excludes               stringsFlag
+		canaries               stringsFlag
+		onComplete             = flag.String("oncomplete", "", "command to execute when the deploy finishes (regardless of success). The result is described in SV_ROLLOUT_* environment variables, and in the JSON file named by $SV_ROLLOUT_RESULT_FILE")
+		onSuccess              = flag.String("onsuccess", "", "command to execute, before -oncomplete, when the deploy succeeds")
+		onFailure              = flag.String("onfailure", "", "command to execute, before -oncomplete, when the deploy fails, is aborted or is rolled back")
 		verbose                = flag.Bool("verbose", false, "print more information about what's going on")
+		phases                 phasesFlag
+		configPath             = flag.String("config", "", "YAML file of named profiles to read settings from. Flags given on the command line override the file")
+		profile                = flag.String("profile", "default", "profile to use from the -config file")
+		dryRun                 = flag.Bool("dry-run", false, "print the resolved rollout plan and exit without restarting anything")
+		output                 = flag.String("output", "text", "output format: text or json. With json, every change in the rollout's state is printed as a JSON object, one per line")
+		healthCheck            = flag.String("health-check", "", "probe that must pass after each restart: http://host:port/path, tcp://host:port or exec:command. \"{service}\" is replaced with the service name")
+		healthCheckStatus      = flag.Int("health-check-status", 200, "HTTP status expected from an http:// health check")
+		healthCheckTimeout     = flag.Int("health-check-timeout", 30, "number of seconds to wait for a restarted service to pass its health check")
+		svdir                  = flag.String("svdir", envSvdir(), "directory containing the services to restart. Defaults to $SVDIR, or /etc/service")
+		stableFor              = flag.Int("stable-for", 0, "number of seconds a restarted service must stay up (without runit restarting it) for its restart to count as successful")
+		maxStartsPerMinute     = flag.Float64("max-starts-per-minute", 0, "maximum rate at which restarts are started, however many may run concurrently. 0 for no limit")
+		startBurst             = flag.Int("start-burst", 1, "number of restarts that may start at once, before -max-starts-per-minute paces the rest")
+		minStartInterval       = flag.Float64("min-start-interval", 0, "minimum number of seconds between the starts of consecutive restarts")
+		bake                   = flag.Int("bake", 0, "number of seconds to wait after each phase but the last, re-checking that its services are still up and healthy, before the next phase begins")
+		approve                = flag.String("approve", "", "wait for approval before each phase after the first: prompt (ask on the terminal), file:<path> (wait for the file to be created) or socket:<path> (wait for \"approve\" on a UNIX socket)")
+		stateFile              = flag.String("state-file", "", "file to record the outcome of each restart in as the rollout progresses, so that it can be resumed. Removed once the rollout succeeds")
+		rollback               = flag.String("rollback", "", "command to run for each service restarted by a rollout that fails with too many timeouts or failures, before restarting it again. \"{service}\" is replaced with the service name")
+		preRestart             = flag.String("pre-restart", "", "command to run before restarting each service, e.g. to drain it from a load balancer. \"{service}\" is replaced with the service name, and the restart is described in SV_ROLLOUT_* environment variables")
+		postRestart            = flag.String("post-restart", "", "command to run after restarting each service, whatever the result (given in $SV_ROLLOUT_RESULT). \"{service}\" is replaced with the service name")
+		hookTimeout            = flag.Int("hook-timeout", 60, "number of seconds -pre-restart, -post-restart and -rollback commands may run before they're killed and count as failed. 0 for no limit")
+		hookFailure            = flag.String("hook-failure", "fail", "what a failed -pre-restart or -post-restart command means: fail (the service's restart fails; a failed -pre-restart skips the restart) or ignore (it's logged and the rollout carries on)")
+		lockScope              = flag.String("lock-scope", "services", "what to lock so that two rollouts can't restart the same services at once: services (one rollout per service), svdir (one rollout per service directory) or none")
+		lockDir                = flag.String("lock-dir", defaultLockDir, "directory to keep lock files in")
+		lockWait               = flag.Duration("lock-wait", 0, "how long to wait for another rollout holding the lock to finish (e.g. \"10m\"), rather than failing straight away")
+		metricsTextfileDir     = flag.String("metrics-textfile-dir", "", "node_exporter textfile collector directory to write Prometheus metrics about the rollout to when it finishes")
+		metricsListen          = flag.String("metrics-listen", "", "address (e.g. \":9199\") to serve Prometheus metrics about the rollout on, at /metrics, while it's in progress")
+		metricsName            = flag.String("metrics-name", "", "value of the rollout label on every metric, and name of the -metrics-textfile-dir file. Defaults to the -profile")
+		statsdAddr             = flag.String("statsd-addr", "127.0.0.1:8125", "address of the dogstatsd agent to send metrics and events to. Empty to send none")
+		statsdNamespace        = flag.String("statsd-namespace", "sv-rollout.", "prefix for the name of every statsd metric")
+		reportJSONPath         = flag.String("report-json", "", "file to write the outcome of every service to, as JSON, when the rollout finishes")
+		reportJUnitPath        = flag.String("report-junit", "", "file to write the outcome of every service to, as JUnit XML test cases, when the rollout finishes")
+		order                  = flag.String("order", orderRandom, "order to restart services in: random, sorted, reverse or file (-order-file). Canaries are taken from the front")
+		orderFile              = flag.String("order-file", "", "file listing services in the order to restart them, one per line, for -order file. Services it doesn't list are restarted last, in sorted order")
+		seed                   = flag.Int64("seed", 0, "seed for -order random, to repeat the order of an earlier rollout. Chosen at random, and logged, unless given")
+		resume                 = flag.Bool("resume", false, "continue the rollout recorded in -state-file, skipping services it already restarted successfully. Starts a new rollout if there's no state file")
 	)
+	flag.Var(&canaryRatio, "canary-ratio", "canary nodes are restarted first. If they fail, the deploy is failed. Rounded up to the nearest node, unless set to zero")
+	flag.Var(&canaryTimeoutTolerance, "canary-timeout-tolerance", "ratio of canary nodes that are permitted to time out without causing the deploy to fail")
+	flag.Var(&chunkRatio, "chunk-ratio", "after canary nodes, ratio of remaining nodes permitted to restart concurrently")
+	flag.Var(&timeoutTolerance, "timeout-tolerance", "ratio of total nodes whose restarts may time out and still consider the deploy a success")
+	flag.Var(&canaryFailureTolerance, "canary-failure-tolerance", "ratio of canary nodes that are permitted to fail without causing the deploy to fail")
+	flag.Var(&failureTolerance, "failure-tolerance", "ratio of non-canary nodes whose restarts may fail and still consider the deploy a success")
+	flag.Var(&patterns, "pattern", "(required) glob pattern to match service directory entries (e.g. \"borg-shopify-*\"), or a regular expression prefixed with \"re:\". May be repeated")
+	flag.Var(&excludes, "exclude", "glob pattern (or \"re:\"-prefixed regular expression) of services not to restart, even if they match -pattern. May be repeated")
+	flag.Var(&canaries, "canary", "service to restart first, as a canary, whatever -order says. May be repeated; the canary phase is enlarged to hold them all")
+	flag.Var(&statsdTags, "statsd-tag", "tag (e.g. \"env:production\") to add to every statsd metric and event. May be repeated")
+	flag.Var(&phases, "phase", "a rollout phase, e.g. \"name=canary,ratio=0.05,chunk-ratio=1,timeout-tolerance=0,failure-tolerance=0,failure-count=0\". May be repeated; overrides the canary and chunk options. The last phase restarts all remaining services")
 
 	flag.Parse()
+	var serviceSettings map[string]serviceSettings
+	if *configPath != "" {
+		var err error
+		if serviceSettings, err = loadConfigFile(flag.CommandLine, *configPath, *profile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 	config := config{
-		CanaryRatio:            *canaryRatio,
-		CanaryTimeoutTolerance: *canaryTimeoutTolerance,
-		ChunkRatio:             *chunkRatio,
-		TimeoutTolerance:       *timeoutTolerance,
+		Patterns:               patterns,
+		Excludes:               excludes,
+		CanaryRatio:            canaryRatio,
+		CanaryTimeoutTolerance: canaryTimeoutTolerance,
+		ChunkRatio:             chunkRatio,
+		TimeoutTolerance:       timeoutTolerance,
+		CanaryFailureTolerance: canaryFailureTolerance,
+		FailureTolerance:       failureTolerance,
+		CanaryFailureCount:     *canaryFailureCount,
+		FailureCount:           *failureCount,
 		Timeout:                *timeout,
 		OnComplete:             *onComplete,
+		OnSuccess:              *onSuccess,
+		OnFailure:              *onFailure,
+		ExplicitPhases:         phases,
+		DryRun:                 *dryRun,
+		Output:                 *output,
+		StableFor:              time.Duration(*stableFor) * time.Second,
+		Svdir:                  *svdir,
+		StateFile:              *stateFile,
+		Resume:                 *resume,
+		Rollback:               *rollback,
+		MaxStartsPerMinute:     *maxStartsPerMinute,
+		StartBurst:             *startBurst,
+		MinStartInterval:       time.Duration(*minStartInterval * float64(time.Second)),
+		Bake:                   time.Duration(*bake) * time.Second,
+		PreRestart:             *preRestart,
+		PostRestart:            *postRestart,
+		HookTimeout:            time.Duration(*hookTimeout) * time.Second,
+		HookFailure:            *hookFailure,
+		LockScope:              *lockScope,
+		LockDir:                *lockDir,
+		LockWait:               *lockWait,
+		MetricsTextfileDir:     *metricsTextfileDir,
+		MetricsListen:          *metricsListen,
+		MetricsName:            *metricsName,
+		ReportJSON:             *reportJSONPath,
+		ReportJUnit:            *reportJUnitPath,
+		HealthCheckStatus:      *healthCheckStatus,
+		HealthCheckTimeout:     time.Duration(*healthCheckTimeout) * time.Second,
+		ServiceSettings:        serviceSettings,
+		Order:                  *order,
+		OrderFile:              *orderFile,
+		Seed:                   *seed,
+		Canaries:               canaries,
+	}
+	seedGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		seedGiven = seedGiven || f.Name == "seed"
+	})
+	if !seedGiven {
+		config.Seed = time.Now().UnixNano()
+	}
+	if config.MetricsName == "" {
+		config.MetricsName = *profile
+	}
+	if *healthCheck != "" {
+		probe, err := rollout.ParseProbe(*healthCheck, *healthCheckStatus)
+		if err != nil {
+			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		config.HealthCheck = &rollout.HealthCheck{
+			Probe:    probe,
+			Timeout:  config.HealthCheckTimeout,
+			Interval: rollout.DefaultHealthCheckInterval,
+		}
 	}
-	config.AssertValid(*pattern)
+	if *approve != "" {
+		approver, err := parseApprover(*approve)
+		if err != nil {
+			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		config.Approver = approver
+	}
+	config.AssertValid()
+	configureStatsd(*statsdAddr, *statsdNamespace, statsdTags)
 
 	Verbose = *verbose
+	if config.Output == "json" {
+		// keep stdout for events only.
+		log.SetOutput(os.Stderr)
+		report = reportJSON
+	}
 
 	if Verbose {
-		log.Printf("[debug] initializing with pattern=%s, config %#v", *pattern, config)
+		log.Printf("[debug] initializing with config %#v", config)
 	}
 
-	os.Exit(run(*pattern, config))
+	os.Exit(run(config))
 }
 
-func run(servicePattern string, c config) int {
-	services, err := getServices(servicePattern)
+func run(c config) (status int) {
+	services, err := getServices(c.Svdir, c.Patterns, c.Excludes)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer runCompletionHandler(c.OnComplete)
+	start := time.Now()
+	// each service's outcome, recorded once the rollout starts.
+	var recorded *outcomes
+	// deferred first so it runs last: once Run or Rollback has returned, and
+	// so every worker has stopped, no more outcomes can be recorded. It runs
+	// the completion handlers however the rollout ends, even if it fails
+	// before it starts.
+	defer func() {
+		if c.DryRun {
+			return
+		}
+		if recorded == nil {
+			recorded = newOutcomes(services, nil)
+		}
+		result := recorded.result(c, status, start)
+		summary := os.Stdout
+		if c.Output == "json" {
+			// keep stdout for events only.
+			summary = os.Stderr
+		}
+		writeReports(summary, c, result)
+		runCompletionHandlers(c, result)
+	}()
 
-	d := NewDeployment(services, c)
-	if err := d.Run(); err != nil {
-		return 1
+	if c.Order == orderRandom {
+		log.Printf("restarting %d services in random order, -seed %d", len(services), c.Seed)
 	}
-	return 0
-}
-
-func getServices(pattern string) (services []string, err error) {
-	var fullpaths []string
-	fullpaths, err = globServices(svdir + "/" + pattern)
+	ordered, err := orderServices(c, services)
 	if err != nil {
-		return nil, err
+		log.Println(err)
+		return exitFailure
 	}
-	for _, p := range fullpaths {
-		services = append(services, path.Base(p))
+	services = ordered
+	if err = checkCanaries(c.Canaries, services); err != nil {
+		log.Println(err)
+		return exitFailure
 	}
-	shuffle(services)
-	return
+
+	if !c.DryRun {
+		// held until we exit, so that the state file isn't read while another
+		// rollout is writing it, either.
+		lock, err := acquireLock(lockPaths(c, services), lockHolder{
+			Pid:       os.Getpid(),
+			Patterns:  c.Patterns,
+			StartedAt: time.Now(),
+		}, c.LockWait)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		defer lock.Release()
+	}
+
+	if c.Overrides, err = loadOverrides(c, services); err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	var state journalState
+	resuming := false
+	if c.Resume {
+		state, err = loadJournal(c.StateFile)
+		switch {
+		case os.IsNotExist(err):
+			log.Printf("%s not found, starting a new rollout", c.StateFile)
+		case err != nil:
+			log.Println(err)
+			return exitFailure
+		case state.Header.ConfigHash != c.Hash():
+			log.Printf("%s records a different rollout (of %v, started %s), not resuming", c.StateFile, state.Header.Patterns, state.Header.StartedAt.Format(time.RFC3339))
+			return exitFailure
+		default:
+			resuming = true
+			services = state.resumeOrder(services)
+		}
+	}
+
+	d := rollout.NewDeployment(services, c.Options())
+	if resuming {
+		skipped := d.Resume(state.Succeeded)
+		log.Printf("resuming rollout started %s: %d of %d services already restarted", state.Header.StartedAt.Format(time.RFC3339), skipped, len(services))
+	}
+	if c.DryRun {
+		if err := writePlan(os.Stdout, resolvePlan(d, c), c.Output); err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		return exitSuccess
+	}
+
+	// state.Succeeded is empty unless resuming.
+	recorded = newOutcomes(services, state.Succeeded)
+	report = recorded.record(report)
+
+	var phases []string
+	for _, p := range d.Plan().Phases {
+		phases = append(phases, p.Name)
+	}
+	m := newMetrics(c.MetricsName, len(services), phases, start)
+	report = m.record(report)
+	if c.MetricsListen != "" {
+		l, err := serveMetrics(c.MetricsListen, m)
+		if err != nil {
+			log.Println("couldn't serve metrics:", err)
+			return exitFailure
+		}
+		defer l.Close()
+	}
+	defer func() {
+		m.finish(status)
+		if c.MetricsTextfileDir == "" {
+			return
+		}
+		if err := writeTextfile(c.MetricsTextfileDir, m); err != nil {
+			log.Println("couldn't write metrics:", err)
+		}
+	}()
+
+	var j *journal
+	if c.StateFile != "" {
+		if resuming {
+			j, err = appendJournal(c.StateFile)
+		} else {
+			j, err = createJournal(c.StateFile, journalHeader{
+				Patterns:   c.Patterns,
+				Excludes:   c.Excludes,
+				ConfigHash: c.Hash(),
+				StartedAt:  time.Now(),
+				Services:   services,
+			})
+		}
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		report = j.record(report)
+	}
+
+	// cancelled on SIGINT or SIGTERM, stopping the rollout or rollback.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+
+	err = d.Run(ctx)
+	status = exitFailure
+	switch err {
+	case nil:
+		status = exitSuccess
+	case rollout.ErrAborted, rollout.ErrNotApproved:
+		status = exitAborted
+	default:
+		if !rollbackable(err) || c.Rollback == "" {
+			break
+		}
+		rb := rollout.NewRollback(d, rollbackRestarter{
+			Restarter:  rollout.Runit{Svdir: c.Svdir},
+			ctx:        ctx,
+			command:    c.Rollback,
+			svdir:      c.Svdir,
+			cmdTimeout: c.HookTimeout,
+		})
+		switch rb.Rollback(ctx) {
+		case nil:
+			status = exitRolledBack
+		case rollout.ErrAborted:
+			status = exitAborted
+		default:
+			status = exitRollbackFailed
+		}
+	}
+
+	// Run and Rollback return only once their workers have stopped, so
+	// nothing's left to write to the journal.
+	if j != nil {
+		j.Close()
+		// a finished or rolled-back rollout has nothing to resume.
+		if status == exitSuccess || status == exitRolledBack {
+			os.Remove(c.StateFile)
+		}
+	}
+	return status
 }
 
-// Fisher-Yates, Sattolo's algorithm
-func shuffle(a []string) {
-	for i := range a {
-		j := rand.Intn(i + 1)
-		a[i], a[j] = a[j], a[i]
+// rollbackable reports whether a rollout that failed with err should be rolled
+// back.
+func rollbackable(err error) bool {
+	switch err.(type) {
+	case rollout.ErrBakeFailed:
+		return true
 	}
+	return err == rollout.ErrTooManyFailures || err == rollout.ErrTooManyTimeouts
 }
 
-func runCompletionHandler(command string) {
-	if command == "" {
-		return
+// handleSignals calls abort to stop the deployment gracefully on the first
+// SIGINT or SIGTERM, and exits immediately on the second.
+func handleSignals(abort func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Printf("received %s: not starting any more restarts, waiting for those in progress. Send it again to exit immediately", sig)
+	abort()
+	sig = <-signals
+	log.Printf("received %s again: exiting immediately", sig)
+	// hooks, probes and rollback commands run in their own process groups, so
+	// wouldn't otherwise be interrupted along with us.
+	rollout.KillCommands()
+	os.Exit(exitAborted)
+}
+
+// envSvdir returns the service directory to use by default. Like runit's own
+// `sv`, we honour $SVDIR.
+func envSvdir() string {
+	if dir := os.Getenv("SVDIR"); dir != "" {
+		return dir
 	}
-	cmd := exec.Command("sh", "-c", command)
-	output, err := cmd.CombinedOutput()
+	return defaultSvdir
+}
+
+// getServices returns the names of the services in svdir matching any of
+// patterns and none of excludes, for orderServices to put in order.
+func getServices(svdir string, patterns, excludes []string) (services []string, err error) {
+	includes, err := newServiceMatchers(patterns)
 	if err != nil {
-		log.Println("completion handler failed:", err)
-		return
+		return nil, err
+	}
+	exclusions, err := newServiceMatchers(excludes)
+	if err != nil {
+		return nil, err
 	}
-	log.Println("completion handler:", command)
-	log.Println(string(output))
+
+	seen := make(map[string]bool)
+	for _, m := range includes {
+		var fullpaths []string
+		fullpaths, err = globServices(m.globPattern(svdir))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range fullpaths {
+			service := path.Base(p)
+			// globs were already matched by globServices.
+			if m.regex != nil && !m.Match(service) {
+				continue
+			}
+			if seen[service] || matchesAny(exclusions, service) {
+				continue
+			}
+			seen[service] = true
+			services = append(services, service)
+		}
+	}
+	return
 }
 
 var globServices = filepath.Glob
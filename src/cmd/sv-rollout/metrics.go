@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"rollout"
+)
+
+// The outcomes counted by sv_rollout_restarts_total, always all exported so
+// that rates can be taken of outcomes that haven't happened yet.
+var metricOutcomes = []string{
+	rollout.EventSucceeded,
+	rollout.EventTimedOut,
+	rollout.EventFailed,
+	rollout.EventUnstable,
+	rollout.EventPreempted,
+}
+
+// The statuses exported by sv_rollout_last_status.
+var metricStatuses = []string{"success", "failure", "aborted", "rolled_back", "rollback_failed"}
+
+// metrics keeps Prometheus metrics about a rollout up to date from its
+// events, for `-metrics-textfile-dir` and `-metrics-listen`.
+type metrics struct {
+	sync.Mutex
+	name     string
+	services int
+	start    time.Time
+	// end is zero until the rollout finishes.
+	end      time.Time
+	status   string
+	outcomes map[string]int
+	phases   []string
+	inFlight map[string]int
+}
+
+// newMetrics starts tracking a rollout of services in phases, labelling every
+// metric with rollout="<name>".
+func newMetrics(name string, services int, phases []string, start time.Time) *metrics {
+	return &metrics{
+		name:     name,
+		services: services,
+		start:    start,
+		outcomes: make(map[string]int),
+		phases:   append([]string{}, phases...),
+		inFlight: make(map[string]int),
+	}
+}
+
+// record tracks restarts. It's meant to wrap `report`.
+func (m *metrics) record(report func(rollout.Event)) func(rollout.Event) {
+	return func(e rollout.Event) {
+		report(e)
+		m.Lock()
+		defer m.Unlock()
+		switch e.Event {
+		case rollout.EventRestarting:
+			m.addPhase(e.Phase)
+			m.inFlight[e.Phase]++
+		case rollout.EventSucceeded, rollout.EventTimedOut, rollout.EventFailed, rollout.EventUnstable, rollout.EventPreempted:
+			m.inFlight[e.Phase]--
+			if e.Phase != rollout.RollbackPhase {
+				m.outcomes[e.Event]++
+			}
+		}
+	}
+}
+
+func (m *metrics) addPhase(phase string) {
+	for _, p := range m.phases {
+		if p == phase {
+			return
+		}
+	}
+	m.phases = append(m.phases, phase)
+}
+
+// finish records the end of the rollout, with the given exit status.
+func (m *metrics) finish(exit int) {
+	m.Lock()
+	defer m.Unlock()
+	m.end = time.Now()
+	m.status = completionStatus(exit)
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *metrics) write(w io.Writer) error {
+	m.Lock()
+	defer m.Unlock()
+	var b bytes.Buffer
+	label := fmt.Sprintf(`rollout="%s"`, escapeLabel(m.name))
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	boolValue := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	end := m.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	metric("sv_rollout_running", "gauge", "Whether the rollout is still in progress.")
+	fmt.Fprintf(&b, "sv_rollout_running{%s} %d\n", label, boolValue(m.end.IsZero()))
+	metric("sv_rollout_services", "gauge", "Number of services in the rollout.")
+	fmt.Fprintf(&b, "sv_rollout_services{%s} %d\n", label, m.services)
+	metric("sv_rollout_start_timestamp_seconds", "gauge", "When the rollout started.")
+	fmt.Fprintf(&b, "sv_rollout_start_timestamp_seconds{%s} %d\n", label, m.start.Unix())
+	metric("sv_rollout_duration_seconds", "gauge", "How long the rollout took, or has taken so far.")
+	fmt.Fprintf(&b, "sv_rollout_duration_seconds{%s} %.3f\n", label, end.Sub(m.start).Seconds())
+
+	metric("sv_rollout_restarts_total", "counter", "Number of restarts finished, by outcome.")
+	for _, outcome := range metricOutcomes {
+		fmt.Fprintf(&b, "sv_rollout_restarts_total{%s,outcome=\"%s\"} %d\n", label, outcome, m.outcomes[outcome])
+	}
+	metric("sv_rollout_restarts_in_flight", "gauge", "Number of restarts in progress, by phase.")
+	for _, phase := range m.phases {
+		fmt.Fprintf(&b, "sv_rollout_restarts_in_flight{%s,phase=\"%s\"} %d\n", label, escapeLabel(phase), m.inFlight[phase])
+	}
+
+	if !m.end.IsZero() {
+		metric("sv_rollout_last_timestamp_seconds", "gauge", "When the last rollout finished.")
+		fmt.Fprintf(&b, "sv_rollout_last_timestamp_seconds{%s} %d\n", label, m.end.Unix())
+		metric("sv_rollout_last_status", "gauge", "The status the last rollout finished with, as 1 for that status and 0 for the rest.")
+		for _, status := range metricStatuses {
+			fmt.Fprintf(&b, "sv_rollout_last_status{%s,status=\"%s\"} %d\n", label, status, boolValue(status == m.status))
+		}
+	}
+	_, err := b.WriteTo(w)
+	return err
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// writeTextfile writes the metrics to sv-rollout-<name>.prom in dir, for
+// node_exporter's textfile collector. It's written to a temporary file first
+// and renamed into place, so that node_exporter never sees half of it.
+func writeTextfile(dir string, m *metrics) error {
+	f, err := ioutil.TempFile(dir, ".sv-rollout-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err = m.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, "sv-rollout-"+m.name+".prom"))
+}
+
+// serveMetrics serves the metrics at /metrics on addr until the returned
+// listener is closed.
+func serveMetrics(addr string, m *metrics) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(l, mux)
+	return l, nil
+}
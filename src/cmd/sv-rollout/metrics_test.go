@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestMetrics(t *testing.T) {
+
+	Convey("Tracking metrics for a rollout", t, func() {
+		start := time.Unix(1464782400, 0)
+		m := newMetrics("jobs", 3, []string{"canary", "main"}, start)
+		record := m.record(func(rollout.Event) {})
+		record(rollout.Event{Event: rollout.EventRestarting, Phase: "canary", Service: "a"})
+		record(rollout.Event{Event: rollout.EventSucceeded, Phase: "canary", Service: "a"})
+		record(rollout.Event{Event: rollout.EventRestarting, Phase: "main", Service: "b"})
+		record(rollout.Event{Event: rollout.EventRestarting, Phase: "main", Service: "c"})
+		record(rollout.Event{Event: rollout.EventFailed, Phase: "main", Service: "c"})
+		write := func() string {
+			var b bytes.Buffer
+			So(m.write(&b), ShouldBeNil)
+			return b.String()
+		}
+
+		Convey("should count outcomes and restarts in flight while it runs", func() {
+			out := write()
+			So(out, ShouldContainSubstring, "sv_rollout_running{rollout=\"jobs\"} 1\n")
+			So(out, ShouldContainSubstring, "sv_rollout_services{rollout=\"jobs\"} 3\n")
+			So(out, ShouldContainSubstring, "sv_rollout_start_timestamp_seconds{rollout=\"jobs\"} 1464782400\n")
+			So(out, ShouldContainSubstring, "# TYPE sv_rollout_restarts_total counter\n")
+			So(out, ShouldContainSubstring, "sv_rollout_restarts_total{rollout=\"jobs\",outcome=\"succeeded\"} 1\n")
+			So(out, ShouldContainSubstring, "sv_rollout_restarts_total{rollout=\"jobs\",outcome=\"failed\"} 1\n")
+			So(out, ShouldContainSubstring, "sv_rollout_restarts_total{rollout=\"jobs\",outcome=\"timed_out\"} 0\n")
+			So(out, ShouldContainSubstring, "sv_rollout_restarts_in_flight{rollout=\"jobs\",phase=\"canary\"} 0\n")
+			So(out, ShouldContainSubstring, "sv_rollout_restarts_in_flight{rollout=\"jobs\",phase=\"main\"} 1\n")
+			So(out, ShouldNotContainSubstring, "sv_rollout_last_status")
+		})
+		Convey("should record the status once it's finished", func() {
+			record(rollout.Event{Event: rollout.EventSucceeded, Phase: "main", Service: "b"})
+			m.finish(exitRolledBack)
+			out := write()
+			So(out, ShouldContainSubstring, "sv_rollout_running{rollout=\"jobs\"} 0\n")
+			So(out, ShouldContainSubstring, "sv_rollout_last_status{rollout=\"jobs\",status=\"rolled_back\"} 1\n")
+			So(out, ShouldContainSubstring, "sv_rollout_last_status{rollout=\"jobs\",status=\"success\"} 0\n")
+			So(out, ShouldContainSubstring, "sv_rollout_last_timestamp_seconds{rollout=\"jobs\"} ")
+		})
+		Convey("should not count rollbacks as restarts", func() {
+			record(rollout.Event{Event: rollout.EventRestarting, Phase: rollout.RollbackPhase, Service: "a"})
+			record(rollout.Event{Event: rollout.EventFailed, Phase: rollout.RollbackPhase, Service: "a"})
+			out := write()
+			So(out, ShouldContainSubstring, "sv_rollout_restarts_total{rollout=\"jobs\",outcome=\"failed\"} 1\n")
+			So(out, ShouldContainSubstring, "sv_rollout_restarts_in_flight{rollout=\"jobs\",phase=\"rollback\"} 0\n")
+		})
+
+		Convey("should write them to a textfile collector directory", func() {
+			dir, _ := ioutil.TempDir("", "sv-rollout-metrics")
+			defer os.RemoveAll(dir)
+			m.finish(exitSuccess)
+			So(writeTextfile(dir, m), ShouldBeNil)
+			contents, err := ioutil.ReadFile(filepath.Join(dir, "sv-rollout-jobs.prom"))
+			So(err, ShouldBeNil)
+			So(string(contents), ShouldEqual, write())
+			files, _ := ioutil.ReadDir(dir)
+			So(len(files), ShouldEqual, 1)
+		})
+
+		Convey("should serve them over HTTP", func() {
+			l, err := serveMetrics("127.0.0.1:0", m)
+			So(err, ShouldBeNil)
+			defer l.Close()
+			resp, err := http.Get("http://" + l.Addr().String() + "/metrics")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			So(string(body), ShouldContainSubstring, "sv_rollout_restarts_in_flight{rollout=\"jobs\",phase=\"main\"} 1\n")
+		})
+	})
+}
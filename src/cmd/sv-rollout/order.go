@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The orders `-order` can restart services in.
+const (
+	orderRandom  = "random"
+	orderSorted  = "sorted"
+	orderReverse = "reverse"
+	orderFile    = "file"
+)
+
+// orderServices returns services in the order given by `-order`. A random
+// order is determined by c.Seed alone, whatever order the services were
+// found in.
+func orderServices(c config, services []string) ([]string, error) {
+	ordered := append([]string(nil), services...)
+	sort.Strings(ordered)
+	switch c.Order {
+	case orderRandom:
+		shuffle(ordered, rand.New(rand.NewSource(c.Seed)))
+	case orderReverse:
+		sort.Sort(sort.Reverse(sort.StringSlice(ordered)))
+	case orderFile:
+		listed, err := readOrderFile(c.OrderFile)
+		if err != nil {
+			return nil, err
+		}
+		ordered = orderByList(ordered, listed)
+	}
+	return ordered, nil
+}
+
+// Fisher-Yates, Sattolo's algorithm
+func shuffle(a []string, r *rand.Rand) {
+	for i := range a {
+		j := r.Intn(i + 1)
+		a[i], a[j] = a[j], a[i]
+	}
+}
+
+// readOrderFile reads the service names listed in an `-order-file`, one per
+// line, ignoring blank lines and those starting with "#".
+func readOrderFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var listed []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		listed = append(listed, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return listed, nil
+}
+
+// orderByList returns the services listed, in the order listed, followed by
+// the rest of services, in the order given.
+func orderByList(services, listed []string) []string {
+	remaining := make(map[string]bool)
+	for _, svc := range services {
+		remaining[svc] = true
+	}
+	var ordered []string
+	for _, svc := range listed {
+		if remaining[svc] {
+			ordered = append(ordered, svc)
+			delete(remaining, svc)
+		}
+	}
+	if len(remaining) > 0 {
+		log.Printf("%d services aren't listed in -order-file, restarting them last", len(remaining))
+	}
+	for _, svc := range services {
+		if remaining[svc] {
+			ordered = append(ordered, svc)
+		}
+	}
+	return ordered
+}
+
+// checkCanaries returns an error if any `-canary` isn't among services.
+func checkCanaries(canaries, services []string) error {
+	found := make(map[string]bool)
+	for _, svc := range services {
+		found[svc] = true
+	}
+	for _, svc := range canaries {
+		if !found[svc] {
+			return fmt.Errorf("-canary %s isn't one of the services matched by -pattern", svc)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrder(t *testing.T) {
+
+	Convey("Ordering services", t, func() {
+		services := []string{"c", "a", "h", "e", "b", "g", "d", "f"}
+
+		Convey("should shuffle them randomly", func() {
+			ordered, err := orderServices(config{Order: orderRandom, Seed: 1}, services)
+			So(err, ShouldBeNil)
+			So(len(ordered), ShouldEqual, len(services))
+			for _, svc := range services {
+				So(ordered, ShouldContain, svc)
+			}
+			// Tiny possibility of random failure.
+			So(ordered, ShouldNotResemble, []string{"a", "b", "c", "d", "e", "f", "g", "h"})
+		})
+		Convey("should shuffle them the same way with the same seed", func() {
+			first, _ := orderServices(config{Order: orderRandom, Seed: 42}, services)
+			reversed := []string{"f", "d", "g", "b", "e", "h", "a", "c"}
+			second, _ := orderServices(config{Order: orderRandom, Seed: 42}, reversed)
+			So(second, ShouldResemble, first)
+			other, _ := orderServices(config{Order: orderRandom, Seed: 43}, services)
+			So(other, ShouldNotResemble, first)
+		})
+		Convey("should sort them, or reverse them", func() {
+			ordered, _ := orderServices(config{Order: orderSorted}, services)
+			So(ordered, ShouldResemble, []string{"a", "b", "c", "d", "e", "f", "g", "h"})
+			ordered, _ = orderServices(config{Order: orderReverse}, services)
+			So(ordered, ShouldResemble, []string{"h", "g", "f", "e", "d", "c", "b", "a"})
+			So(services[0], ShouldEqual, "c")
+		})
+		Convey("should follow an order file, then sort the rest", func() {
+			f, _ := ioutil.TempFile("", "sv-rollout-order")
+			defer os.Remove(f.Name())
+			f.WriteString("# known-good first\ng\n\nx\n  d\n")
+			f.Close()
+			ordered, err := orderServices(config{Order: orderFile, OrderFile: f.Name()}, services)
+			So(err, ShouldBeNil)
+			So(ordered, ShouldResemble, []string{"g", "d", "a", "b", "c", "e", "f", "h"})
+
+			_, err = orderServices(config{Order: orderFile, OrderFile: f.Name() + ".missing"}, services)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Checking pinned canaries", t, func() {
+		So(checkCanaries(nil, []string{"a"}), ShouldBeNil)
+		So(checkCanaries([]string{"a"}, []string{"a", "b"}), ShouldBeNil)
+		So(checkCanaries([]string{"c"}, []string{"a", "b"}), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"rollout"
+)
+
+// serviceConfFile is the file in a service's directory that overrides the
+// rollout's settings for that service, as key=value lines.
+const serviceConfFile = "sv-rollout.conf"
+
+// serviceTimeoutEnvFile is the envdir entry, as read by `chpst -e`, that
+// overrides the timeout for a service.
+const serviceTimeoutEnvFile = "env/SV_ROLLOUT_TIMEOUT"
+
+// serviceSettingKeys are the settings a service may override. Each is named
+// after the flag it overrides, with "priority" in addition.
+var serviceSettingKeys = map[string]bool{
+	"timeout":              true,
+	"health-check":         true,
+	"health-check-status":  true,
+	"health-check-timeout": true,
+	"pre-restart":          true,
+	"post-restart":         true,
+	"priority":             true,
+}
+
+// noHook replaces -pre-restart or -post-restart for services that set them to
+// "none".
+var noHook = rollout.HookFunc(func(rollout.HookInfo) error { return nil })
+
+// serviceSettings are the settings overridden for a service, by key.
+type serviceSettings map[string]string
+
+func (s serviceSettings) validate() error {
+	for key := range s {
+		if !serviceSettingKeys[key] {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return nil
+}
+
+// readServiceSettings reads the settings a service overrides in its own
+// directory: its sv-rollout.conf, or the timeout in its env directory.
+func readServiceSettings(svdir, svc string) (serviceSettings, error) {
+	settings := make(serviceSettings)
+	dir := filepath.Join(svdir, svc)
+
+	path := filepath.Join(dir, serviceTimeoutEnvFile)
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		// envdir values end at the first newline.
+		settings["timeout"] = strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	path = filepath.Join(dir, serviceConfFile)
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		return settings, nil
+	case err != nil:
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s: line %d: expected key=value", path, n)
+		}
+		key := strings.TrimSpace(kv[0])
+		if !serviceSettingKeys[key] {
+			return nil, fmt.Errorf("%s: line %d: unknown setting %q", path, n, key)
+		}
+		settings[key] = strings.TrimSpace(kv[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return settings, nil
+}
+
+// profileServiceSettings converts the `services` setting of a config file
+// profile, mapping service names or patterns to the settings they override.
+func profileServiceSettings(v interface{}) (map[string]serviceSettings, error) {
+	services, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a map of services to settings")
+	}
+	all := make(map[string]serviceSettings)
+	for pattern, v := range services {
+		name := fmt.Sprint(pattern)
+		if _, err := newServiceMatcher(name); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", name, err)
+		}
+		values, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a map of settings", name)
+		}
+		settings := make(serviceSettings)
+		for key, value := range values {
+			settings[fmt.Sprint(key)] = scalarValue(value)
+		}
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		all[name] = settings
+	}
+	return all, nil
+}
+
+// settingsFor returns the settings svc overrides: those from its own
+// directory, then those from the config file for patterns matching it, in
+// sorted order, then those for its name, each taking precedence over the
+// last.
+func (c config) settingsFor(svc string) (serviceSettings, error) {
+	settings, err := readServiceSettings(c.Svdir, svc)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for pattern := range c.ServiceSettings {
+		if pattern != svc {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	patterns = append(patterns, svc)
+	for _, pattern := range patterns {
+		m, _ := newServiceMatcher(pattern)
+		if !m.Match(svc) {
+			continue
+		}
+		for key, value := range c.ServiceSettings[pattern] {
+			settings[key] = value
+		}
+	}
+	return settings, nil
+}
+
+// overrides resolves settings into Overrides, falling back to c for anything
+// a setting depends on but doesn't give, such as the health check's timeout.
+func (c config) overrides(settings serviceSettings) (o rollout.Overrides, err error) {
+	seconds := func(key string) (time.Duration, error) {
+		n, err := strconv.Atoi(settings[key])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s: expected a number of seconds, got %q", key, settings[key])
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+
+	if _, ok := settings["timeout"]; ok {
+		if o.Timeout, err = seconds("timeout"); err != nil {
+			return o, err
+		}
+	}
+	if value, ok := settings["priority"]; ok {
+		if o.Priority, err = strconv.Atoi(value); err != nil {
+			return o, fmt.Errorf("priority: expected an integer, got %q", value)
+		}
+	}
+
+	healthCheckTimeout := c.HealthCheckTimeout
+	if _, ok := settings["health-check-timeout"]; ok {
+		if healthCheckTimeout, err = seconds("health-check-timeout"); err != nil {
+			return o, err
+		}
+	}
+	status := c.HealthCheckStatus
+	if value, ok := settings["health-check-status"]; ok {
+		if _, ok := settings["health-check"]; !ok {
+			return o, fmt.Errorf("health-check-status: requires health-check")
+		}
+		if status, err = strconv.Atoi(value); err != nil {
+			return o, fmt.Errorf("health-check-status: expected an HTTP status, got %q", value)
+		}
+	}
+	switch spec, ok := settings["health-check"]; {
+	case spec == "none":
+		o.NoHealthCheck = true
+	case ok:
+		probe, err := rollout.ParseProbe(spec, status)
+		if err != nil {
+			return o, fmt.Errorf("health-check: %s", err)
+		}
+		o.HealthCheck = &rollout.HealthCheck{
+			Probe:    probe,
+			Timeout:  healthCheckTimeout,
+			Interval: rollout.DefaultHealthCheckInterval,
+		}
+	case healthCheckTimeout != c.HealthCheckTimeout && c.HealthCheck != nil:
+		hc := *c.HealthCheck
+		hc.Timeout = healthCheckTimeout
+		o.HealthCheck = &hc
+	}
+
+	hook := func(key string) rollout.Hook {
+		switch command, ok := settings[key]; {
+		case command == "none":
+			return noHook
+		case ok:
+			return rollout.CommandHook{Command: command, Timeout: c.HookTimeout}
+		}
+		return nil
+	}
+	o.PreRestart = hook("pre-restart")
+	o.PostRestart = hook("post-restart")
+	return o, nil
+}
+
+// loadOverrides returns the Overrides for each of services that overrides any
+// settings, from its own directory or the config file.
+func loadOverrides(c config, services []string) (map[string]rollout.Overrides, error) {
+	overrides := make(map[string]rollout.Overrides)
+	for _, svc := range services {
+		settings, err := c.settingsFor(svc)
+		if err != nil {
+			return nil, err
+		}
+		if len(settings) == 0 {
+			continue
+		}
+		if overrides[svc], err = c.overrides(settings); err != nil {
+			return nil, fmt.Errorf("%s: %s", svc, err)
+		}
+		if Verbose {
+			log.Printf("[debug] %s overrides %v", svc, settings)
+		}
+	}
+	return overrides, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestOverrides(t *testing.T) {
+
+	Convey("Overriding settings for individual services", t, func() {
+		svdir, _ := ioutil.TempDir("", "sv-rollout-overrides")
+		defer os.RemoveAll(svdir)
+		write := func(svc, name, contents string) {
+			path := filepath.Join(svdir, svc, name)
+			os.MkdirAll(filepath.Dir(path), 0755)
+			ioutil.WriteFile(path, []byte(contents), 0644)
+		}
+		os.MkdirAll(filepath.Join(svdir, "plain"), 0755)
+		write("slow", "env/SV_ROLLOUT_TIMEOUT", "900\n")
+		write("slow", serviceConfFile, "# drains for a while\npriority = -1\n\nhealth-check=none\n")
+		write("web", serviceConfFile, "health-check=http://127.0.0.1:8080/{service}\nhealth-check-status=204\npre-restart=none\n")
+		c := config{
+			Svdir:              svdir,
+			HealthCheckStatus:  200,
+			HealthCheckTimeout: 30 * time.Second,
+			HookTimeout:        time.Minute,
+		}
+
+		Convey("should read them from each service's directory", func() {
+			settings, err := readServiceSettings(svdir, "slow")
+			So(err, ShouldBeNil)
+			So(settings, ShouldResemble, serviceSettings{"timeout": "900", "priority": "-1", "health-check": "none"})
+			settings, err = readServiceSettings(svdir, "plain")
+			So(err, ShouldBeNil)
+			So(settings, ShouldBeEmpty)
+		})
+		Convey("should reject unknown settings and malformed lines", func() {
+			write("plain", serviceConfFile, "restart-timeout=5\n")
+			_, err := readServiceSettings(svdir, "plain")
+			So(err, ShouldNotBeNil)
+			write("plain", serviceConfFile, "timeout 5\n")
+			_, err = readServiceSettings(svdir, "plain")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should let the config file take precedence, names over patterns", func() {
+			c.ServiceSettings = map[string]serviceSettings{
+				"s*":   {"timeout": "60", "post-restart": "echo hi"},
+				"slow": {"timeout": "1200"},
+			}
+			settings, err := c.settingsFor("slow")
+			So(err, ShouldBeNil)
+			So(settings["timeout"], ShouldEqual, "1200")
+			So(settings["post-restart"], ShouldEqual, "echo hi")
+			So(settings["priority"], ShouldEqual, "-1")
+		})
+
+		Convey("should resolve them, falling back to the global settings", func() {
+			overrides, err := loadOverrides(c, []string{"plain", "slow", "web"})
+			So(err, ShouldBeNil)
+			So(overrides, ShouldNotContainKey, "plain")
+			So(overrides["slow"], ShouldResemble, rollout.Overrides{Timeout: 900 * time.Second, NoHealthCheck: true, Priority: -1})
+
+			web := overrides["web"]
+			probe, _ := rollout.ParseProbe("http://127.0.0.1:8080/{service}", 204)
+			So(web.HealthCheck, ShouldResemble, &rollout.HealthCheck{Probe: probe, Timeout: 30 * time.Second, Interval: rollout.DefaultHealthCheckInterval})
+			So(web.PreRestart, ShouldNotBeNil)
+			So(web.PreRestart.Run(rollout.HookInfo{}), ShouldBeNil)
+			So(web.PostRestart, ShouldBeNil)
+		})
+		Convey("should build hooks with the global hook timeout", func() {
+			o, err := c.overrides(serviceSettings{"post-restart": "echo hi"})
+			So(err, ShouldBeNil)
+			So(o.PostRestart, ShouldResemble, rollout.CommandHook{Command: "echo hi", Timeout: time.Minute})
+		})
+		Convey("should adjust the global health check's timeout", func() {
+			probe, _ := rollout.ParseProbe("tcp://127.0.0.1:80", 200)
+			c.HealthCheck = &rollout.HealthCheck{Probe: probe, Timeout: 30 * time.Second}
+			o, err := c.overrides(serviceSettings{"health-check-timeout": "120"})
+			So(err, ShouldBeNil)
+			So(o.HealthCheck, ShouldResemble, &rollout.HealthCheck{Probe: probe, Timeout: 120 * time.Second})
+			So(c.HealthCheck.Timeout, ShouldEqual, 30*time.Second)
+		})
+		Convey("should reject invalid values", func() {
+			for _, settings := range []serviceSettings{
+				{"timeout": "soon"},
+				{"priority": "high"},
+				{"health-check": "ftp://example.com"},
+				{"health-check-status": "204"},
+			} {
+				_, err := c.overrides(settings)
+				So(err, ShouldNotBeNil)
+			}
+			write("plain", serviceConfFile, "timeout=-5\n")
+			_, err := loadOverrides(c, []string{"plain"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "plain: timeout:")
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix marks a service pattern as a regular expression rather than a
+// shell glob.
+const regexPrefix = "re:"
+
+// stringsFlag collects repeated string flags, such as `-pattern`.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// serviceMatcher matches service names against a shell glob, or against a
+// regular expression if the pattern starts with "re:".
+type serviceMatcher struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+func newServiceMatcher(pattern string) (m serviceMatcher, err error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		m.regex, err = regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+		return m, err
+	}
+	m.glob = pattern
+	_, err = filepath.Match(pattern, "")
+	return m, err
+}
+
+func newServiceMatchers(patterns []string) ([]serviceMatcher, error) {
+	var matchers []serviceMatcher
+	for _, pattern := range patterns {
+		m, err := newServiceMatcher(pattern)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func (m serviceMatcher) Match(service string) bool {
+	if m.regex != nil {
+		return m.regex.MatchString(service)
+	}
+	ok, _ := filepath.Match(m.glob, service)
+	return ok
+}
+
+// globPattern returns the glob to pass to globServices to find candidate
+// services in svdir. Regular expressions have to be checked against every
+// entry.
+func (m serviceMatcher) globPattern(svdir string) string {
+	if m.regex != nil {
+		return svdir + "/*"
+	}
+	return svdir + "/" + m.glob
+}
+
+func matchesAny(matchers []serviceMatcher, service string) bool {
+	for _, m := range matchers {
+		if m.Match(service) {
+			return true
+		}
+	}
+	return false
+}
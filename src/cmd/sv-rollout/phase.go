@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"rollout"
+)
+
+// Phases returns the rollout plan described by the config. If no phases were
+// given explicitly, the canary and chunk settings are translated into a canary
+// phase followed by a phase containing every other service.
+func (c config) Phases() []rollout.Phase {
+	if len(c.ExplicitPhases) > 0 {
+		return c.ExplicitPhases
+	}
+	return rollout.CanaryPhases(
+		rollout.Phase{
+			Ratio:            c.CanaryRatio,
+			TimeoutTolerance: c.CanaryTimeoutTolerance,
+			FailureTolerance: c.CanaryFailureTolerance,
+			FailureCount:     c.CanaryFailureCount,
+		},
+		rollout.Phase{
+			ChunkRatio:       c.ChunkRatio,
+			TimeoutTolerance: c.TimeoutTolerance,
+			FailureTolerance: c.FailureTolerance,
+			FailureCount:     c.FailureCount,
+		},
+	)
+}
+
+// phasesFlag collects repeated `-phase` flags.
+type phasesFlag []rollout.Phase
+
+func (f *phasesFlag) String() string {
+	var names []string
+	for _, p := range *f {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *phasesFlag) Set(s string) error {
+	p, err := rollout.ParsePhase(s)
+	if err != nil {
+		return err
+	}
+	if p.Name == "" {
+		p.Name = fmt.Sprintf("phase-%d", len(*f)+1)
+	}
+	*f = append(*f, p)
+	return nil
+}
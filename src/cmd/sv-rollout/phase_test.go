@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestPhase(t *testing.T) {
+
+	Convey("Repeating the -phase flag", t, func() {
+		var f phasesFlag
+		So(f.Set("ratio=0.1"), ShouldBeNil)
+		So(f.Set("name=rest,chunk-ratio=0.2"), ShouldBeNil)
+		Convey("should name unnamed phases by position", func() {
+			So(f[0].Name, ShouldEqual, "phase-1")
+			So(f[1].Name, ShouldEqual, "rest")
+			So(f.String(), ShouldEqual, "phase-1,rest")
+		})
+	})
+
+	Convey("Without explicit phases", t, func() {
+		c := config{
+			CanaryRatio:            rollout.Ratio(0.1),
+			CanaryTimeoutTolerance: rollout.Ratio(0.5),
+			CanaryFailureTolerance: rollout.Ratio(0.2),
+			ChunkRatio:             rollout.Ratio(0.3),
+			TimeoutTolerance:       rollout.Ratio(0.7),
+			FailureTolerance:       rollout.Ratio(0.05),
+			FailureCount:           3,
+		}
+		Convey("the canary options should become two phases", func() {
+			So(c.Phases(), ShouldResemble, []rollout.Phase{
+				{Name: "canary", Ratio: rollout.Ratio(0.1), ChunkRatio: rollout.Ratio(1), TimeoutTolerance: rollout.Ratio(0.5), FailureTolerance: rollout.Ratio(0.2)},
+				{Name: "main", Ratio: rollout.Ratio(1), ChunkRatio: rollout.Ratio(0.3), TimeoutTolerance: rollout.Ratio(0.7), FailureTolerance: rollout.Ratio(0.05), FailureCount: 3},
+			})
+		})
+	})
+}
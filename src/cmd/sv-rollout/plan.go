@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"rollout"
+)
+
+// resolvePlan returns the plan for d, as printed by `-dry-run`.
+func resolvePlan(d *rollout.Deployment, c config) rollout.Plan {
+	plan := d.Plan()
+	plan.Patterns, plan.Excludes = c.Patterns, c.Excludes
+	if plan.Excludes == nil {
+		plan.Excludes = []string{}
+	}
+	return plan
+}
+
+func writePlan(w io.Writer, plan rollout.Plan, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		return enc.Encode(plan)
+	case "text":
+		fmt.Fprintf(w, "patterns %s", strings.Join(plan.Patterns, " "))
+		if len(plan.Excludes) > 0 {
+			fmt.Fprintf(w, " excluding %s", strings.Join(plan.Excludes, " "))
+		}
+		fmt.Fprintf(w, " match %d services, timeout %ds\n", plan.Services, plan.Timeout)
+		fmt.Fprintf(w, "canaries: %s\n", strings.Join(plan.Canaries, " "))
+		for i, p := range plan.Phases {
+			fmt.Fprintf(w, "phase %d/%d (%s): %d services, %d concurrently, %d timeouts and %d failures permitted (%d and %d in total)\n",
+				i+1, len(plan.Phases), p.Name, len(p.Services), p.Concurrency,
+				p.TimeoutsPermitted, p.FailuresPermitted, p.TotalTimeoutsPermitted, p.TotalFailuresPermitted)
+			if p.Bake > 0 {
+				fmt.Fprintf(w, "  then bake for %ds\n", p.Bake)
+			}
+			for _, svc := range p.Services {
+				if timeout, ok := plan.Timeouts[svc]; ok {
+					fmt.Fprintf(w, "  %s (timeout %ds)\n", svc, timeout)
+				} else {
+					fmt.Fprintf(w, "  %s\n", svc)
+				}
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestPlan(t *testing.T) {
+
+	Convey("Planning a deployment", t, func() {
+		c := config{
+			CanaryRatio:            rollout.Ratio(0.2),
+			CanaryTimeoutTolerance: rollout.Ratio(0.5),
+			ChunkRatio:             rollout.Ratio(0.5),
+			TimeoutTolerance:       rollout.Ratio(0.25),
+			Timeout:                30,
+			Patterns:               []string{"borg-*"},
+			Excludes:               []string{"borg-*-cron"},
+		}
+		depl := rollout.NewDeployment([]string{"a", "b", "c", "d", "e", "f"}, c.Options())
+		plan := resolvePlan(depl, c)
+
+		Convey("should include the patterns the services were matched with", func() {
+			So(plan.Patterns, ShouldResemble, []string{"borg-*"})
+			So(plan.Excludes, ShouldResemble, []string{"borg-*-cron"})
+			So(plan.Canaries, ShouldResemble, []string{"a", "b"})
+		})
+
+		Convey("should print as text", func() {
+			var buf bytes.Buffer
+			So(writePlan(&buf, plan, "text"), ShouldBeNil)
+			So(buf.String(), ShouldEqual, `patterns borg-* excluding borg-*-cron match 6 services, timeout 30s
+canaries: a b
+phase 1/2 (canary): 2 services, 2 concurrently, 1 timeouts and 0 failures permitted (1 and 0 in total)
+  a
+  b
+phase 2/2 (main): 4 services, 2 concurrently, 1 timeouts and 0 failures permitted (2 and 0 in total)
+  c
+  d
+  e
+  f
+`)
+		})
+
+		Convey("should print the timeouts of services that override it", func() {
+			plan.Timeouts = map[string]int{"c": 900}
+			var buf bytes.Buffer
+			So(writePlan(&buf, plan, "text"), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "\n  c (timeout 900s)\n  d\n")
+		})
+
+		Convey("should print as JSON", func() {
+			var buf bytes.Buffer
+			So(writePlan(&buf, plan, "json"), ShouldBeNil)
+			var decoded rollout.Plan
+			So(json.Unmarshal(buf.Bytes(), &decoded), ShouldBeNil)
+			So(decoded, ShouldResemble, plan)
+		})
+
+		Convey("should reject unknown formats", func() {
+			var buf bytes.Buffer
+			So(writePlan(&buf, plan, "xml"), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Planning a deployment without exclusions", t, func() {
+		c := config{Patterns: []string{"borg-*"}, ChunkRatio: rollout.Ratio(1)}
+		plan := resolvePlan(rollout.NewDeployment([]string{"a", "b"}, c.Options()), c)
+		So(plan.Excludes, ShouldResemble, []string{})
+		So(plan.Canaries, ShouldResemble, []string{})
+	})
+}
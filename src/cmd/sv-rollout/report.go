@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"text/tabwriter"
+
+	"rollout"
+)
+
+// printSummary prints the outcome of the rollout, followed by a table of the
+// services that weren't restarted successfully, and why.
+func printSummary(w io.Writer, result rolloutResult) {
+	notRestarted := 0
+	for _, s := range result.Services {
+		if s.Outcome == outcomeNotRestarted {
+			notRestarted++
+		}
+	}
+	counts := result.Counts
+	fmt.Fprintf(w, "rollout %s after %.1fs: %d of %d services restarted successfully, %d timed out, %d failed, %d were unstable, %d were preempted, %d were not restarted\n",
+		result.Status, result.Duration, counts.Successes, len(result.Services),
+		counts.Timeouts, counts.Failures, counts.Unstable, counts.Preempted, notRestarted)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	header := false
+	for _, s := range result.Services {
+		switch s.Outcome {
+		case rollout.EventSucceeded, outcomeSkipped, outcomeNotRestarted:
+			continue
+		}
+		if !header {
+			fmt.Fprintln(tw, "SERVICE\tPHASE\tOUTCOME\tERROR")
+			header = true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Service, s.Phase, s.Outcome, s.Error)
+	}
+	tw.Flush()
+}
+
+// writeJSONReport writes the result to path for `-report-json`, as given to
+// completion handlers.
+func writeJSONReport(path string, result rolloutResult) error {
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// The JUnit XML written by `-report-junit`: a single test suite, with a test
+// case for each service.
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport describes the result as JUnit test cases: restarts that failed
+// or were unstable are failures, those that timed out are errors, and those
+// preempted, not restarted or skipped when resuming are skipped.
+func junitReport(result rolloutResult) junitSuite {
+	suite := junitSuite{
+		Name:  "sv-rollout",
+		Tests: len(result.Services),
+		Time:  fmt.Sprintf("%.3f", result.Duration),
+	}
+	for _, s := range result.Services {
+		c := junitCase{
+			Classname: "sv-rollout." + s.Phase,
+			Name:      s.Service,
+			Time:      fmt.Sprintf("%.3f", s.Duration),
+		}
+		if s.Phase == "" {
+			c.Classname = "sv-rollout"
+		}
+		message := &junitMessage{Message: s.Outcome, Type: s.Outcome, Text: s.Error}
+		switch s.Outcome {
+		case rollout.EventFailed, rollout.EventUnstable:
+			c.Failure = message
+			suite.Failures++
+		case rollout.EventTimedOut:
+			c.Error = message
+			suite.Errors++
+		case rollout.EventPreempted, outcomeNotRestarted, outcomeSkipped:
+			c.Skipped = &junitMessage{Message: s.Outcome}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	return suite
+}
+
+// writeJUnitReport writes the result to path as JUnit XML, for
+// `-report-junit`.
+func writeJUnitReport(path string, result rolloutResult) error {
+	b, err := xml.MarshalIndent(junitReport(result), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), 0644)
+}
+
+// writeReports prints the summary, to w, and writes the `-report-json` and
+// `-report-junit` files, if asked to.
+func writeReports(w io.Writer, c config, result rolloutResult) {
+	printSummary(w, result)
+	if c.ReportJSON != "" {
+		if err := writeJSONReport(c.ReportJSON, result); err != nil {
+			log.Println("couldn't write JSON report:", err)
+		}
+	}
+	if c.ReportJUnit != "" {
+		if err := writeJUnitReport(c.ReportJUnit, result); err != nil {
+			log.Println("couldn't write JUnit report:", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestReport(t *testing.T) {
+
+	Convey("Reporting the result of a rollout", t, func() {
+		result := rolloutResult{
+			Status:     "failure",
+			ExitStatus: exitFailure,
+			Patterns:   []string{"svc-*"},
+			Excludes:   []string{},
+			Duration:   42.08,
+			Counts:     rollout.Counts{Successes: 1, Timeouts: 1, Failures: 1, Preempted: 1},
+			Services: []serviceOutcome{
+				{Service: "svc-a", Phase: "canary", Outcome: "succeeded", Duration: 1.5},
+				{Service: "svc-b", Phase: "main", Outcome: "failed", Error: "restart failed for service 'svc-b': runsv not running", Duration: 0.25},
+				{Service: "svc-c", Phase: "main", Outcome: "timed_out", Error: "timed out"},
+				{Service: "svc-d", Phase: "main", Outcome: "preempted"},
+				{Service: "svc-e", Outcome: "not_restarted"},
+			},
+		}
+
+		Convey("should summarise it, with a table of what went wrong", func() {
+			var b bytes.Buffer
+			printSummary(&b, result)
+			So(b.String(), ShouldEqual, ""+
+				"rollout failure after 42.1s: 1 of 5 services restarted successfully, 1 timed out, 1 failed, 0 were unstable, 1 were preempted, 1 were not restarted\n"+
+				"SERVICE  PHASE  OUTCOME    ERROR\n"+
+				"svc-b    main   failed     restart failed for service 'svc-b': runsv not running\n"+
+				"svc-c    main   timed_out  timed out\n"+
+				"svc-d    main   preempted  \n")
+		})
+		Convey("should leave out the table if nothing went wrong", func() {
+			result.Services = result.Services[:1]
+			var b bytes.Buffer
+			printSummary(&b, result)
+			So(b.String(), ShouldStartWith, "rollout failure after 42.1s: 1 of 1 services")
+			So(b.String(), ShouldNotContainSubstring, "SERVICE")
+		})
+
+		Convey("should describe each service as a JUnit test case", func() {
+			suite := junitReport(result)
+			So(suite.Tests, ShouldEqual, 5)
+			So(suite.Failures, ShouldEqual, 1)
+			So(suite.Errors, ShouldEqual, 1)
+			So(suite.Skipped, ShouldEqual, 2)
+			So(suite.Time, ShouldEqual, "42.080")
+			So(suite.Cases[0], ShouldResemble, junitCase{Classname: "sv-rollout.canary", Name: "svc-a", Time: "1.500"})
+			So(suite.Cases[1].Failure, ShouldResemble, &junitMessage{Message: "failed", Type: "failed", Text: "restart failed for service 'svc-b': runsv not running"})
+			So(suite.Cases[2].Error.Type, ShouldEqual, "timed_out")
+			So(suite.Cases[4].Classname, ShouldEqual, "sv-rollout")
+			So(suite.Cases[4].Skipped, ShouldResemble, &junitMessage{Message: "not_restarted"})
+		})
+
+		Convey("should write report files if asked to", func() {
+			dir, _ := ioutil.TempDir("", "sv-rollout-report")
+			defer os.RemoveAll(dir)
+			c := config{ReportJSON: filepath.Join(dir, "report.json"), ReportJUnit: filepath.Join(dir, "report.xml")}
+			writeReports(ioutil.Discard, c, result)
+
+			b, err := ioutil.ReadFile(c.ReportJSON)
+			So(err, ShouldBeNil)
+			var r rolloutResult
+			So(json.Unmarshal(b, &r), ShouldBeNil)
+			So(r, ShouldResemble, result)
+
+			b, err = ioutil.ReadFile(c.ReportJUnit)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldStartWith, xml.Header+`<testsuite name="sv-rollout" tests="5" failures="1" errors="1" skipped="2" time="42.080">`)
+			var suite junitSuite
+			So(xml.Unmarshal(b, &suite), ShouldBeNil)
+			So(len(suite.Cases), ShouldEqual, 5)
+		})
+	})
+}
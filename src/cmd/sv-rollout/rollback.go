@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"rollout"
+)
+
+// rollbackRestarter runs the `-rollback` command for each service before
+// restarting it, to roll it back after a failed deploy. The command is killed
+// after cmdTimeout (`-hook-timeout`), unless that's zero, or once ctx is done.
+type rollbackRestarter struct {
+	rollout.Restarter
+	ctx        context.Context
+	command    string
+	svdir      string
+	cmdTimeout time.Duration
+}
+
+func (r rollbackRestarter) Restart(service string, timeout time.Duration, started chan<- struct{}) error {
+	if err := rollbackCmd(r.ctx, r.command, r.svdir, service, r.cmdTimeout); err != nil {
+		close(started)
+		return rollout.ErrRestartFailed{Service: service, Message: "rollback command failed: " + err.Error()}
+	}
+	return r.Restarter.Restart(service, timeout, started)
+}
+
+func _rollbackCmd(ctx context.Context, command, svdir, service string, timeout time.Duration) error {
+	env := []string{"SV_ROLLOUT_SERVICE=" + service, "SV_ROLLOUT_SVDIR=" + svdir}
+	return rollout.RunCommand(ctx, rollout.ExpandService(command, service), env, timeout)
+}
+
+// stubbed in tests
+var rollbackCmd = _rollbackCmd
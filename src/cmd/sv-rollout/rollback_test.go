@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
+)
+
+func TestRollback(t *testing.T) {
+
+	Convey("Restarting a service to roll it back", t, func() {
+		var restarted bool
+		r := rollbackRestarter{
+			Restarter: rollout.RestarterFunc(func(s string, t time.Duration, a chan<- struct{}) error {
+				restarted = true
+				close(a)
+				return nil
+			}),
+			command: "ln -sfn previous current",
+			svdir:   "/srv",
+		}
+		started := make(chan struct{})
+
+		Convey("should run the rollback command before restarting", func() {
+			var ran string
+			rollbackCmd = func(ctx context.Context, command, svdir, service string, timeout time.Duration) error {
+				ran = fmt.Sprintf("%s for %s, restarted: %t", command, service, restarted)
+				return nil
+			}
+			So(r.Restart("my-test-service", time.Second, started), ShouldBeNil)
+			So(ran, ShouldEqual, "ln -sfn previous current for my-test-service, restarted: false")
+			So(restarted, ShouldBeTrue)
+		})
+
+		Convey("should fail without restarting if the rollback command fails", func() {
+			rollbackCmd = func(ctx context.Context, command, svdir, service string, timeout time.Duration) error {
+				return errors.New("exit status 1")
+			}
+			err := r.Restart("my-test-service", time.Second, started)
+			So(err, ShouldHaveSameTypeAs, rollout.ErrRestartFailed{})
+			So(err.Error(), ShouldContainSubstring, "rollback command failed: exit status 1")
+			So(restarted, ShouldBeFalse)
+			_, open := <-started
+			So(open, ShouldBeFalse)
+		})
+
+		Reset(func() {
+			rollbackCmd = _rollbackCmd
+		})
+	})
+
+	Convey("Running a rollback command", t, func() {
+		ctx := context.Background()
+		Convey("should expand the service name and set the environment", func() {
+			So(_rollbackCmd(ctx, `test {service} = "$SV_ROLLOUT_SERVICE" && test "$SV_ROLLOUT_SVDIR" = /srv`, "/srv", "svc", time.Second), ShouldBeNil)
+		})
+
+		Convey("should include the command's output in the error", func() {
+			err := _rollbackCmd(ctx, "echo nope; exit 1", "/srv", "svc", time.Second)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "echo nope; exit 1: exit status 1: nope")
+		})
+
+		Convey("should kill a command that runs too long", func() {
+			t1 := time.Now()
+			So(_rollbackCmd(ctx, "sleep 5", "/srv", "svc", 50*time.Millisecond), ShouldNotBeNil)
+			So(time.Since(t1), ShouldBeLessThan, time.Second)
+		})
+
+		Convey("should kill the command once the rollback is cancelled", func() {
+			ctx, cancel := context.WithCancel(ctx)
+			time.AfterFunc(50*time.Millisecond, cancel)
+			t1 := time.Now()
+			err := _rollbackCmd(ctx, "sleep 5", "/srv", "svc", 0)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "sleep 5: context canceled")
+			So(time.Since(t1), ShouldBeLessThan, time.Second)
+		})
+	})
+}
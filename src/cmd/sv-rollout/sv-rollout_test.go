@@ -1,83 +1,124 @@
 package main
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"rollout"
 )
 
 func TestSvRollout(t *testing.T) {
 
-	Convey("Shuffling services", t, func() {
+	Convey("Enumerating services", t, func() {
 		defer func() {
 			globServices = filepath.Glob
 		}()
-		unexpected := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
 		globServices = func(a string) ([]string, error) {
-			var rets []string
-			for _, str := range unexpected {
-				rets = append(rets, "/etc/service/"+(str))
-			}
-			return rets, nil
+			return []string{"/etc/service/borg-shopify-test-1", "/etc/service/borg-shopify-test-2"}, nil
 		}
-		svcs, err := getServices("borg-shopify-*")
+		svcs, err := getServices("/etc/service", []string{"borg-shopify-*"}, nil)
 
-		Convey("Services should be shuffled", func() {
+		Convey("Should generate corrcect service names", func() {
 			So(err, ShouldBeNil)
-			So(len(svcs), ShouldEqual, len(unexpected))
-			for _, str := range unexpected {
-				So(svcs, ShouldContain, str)
-			}
-			// Tiny possibility of random failure.
-			So(svcs, ShouldNotResemble, unexpected)
+			So(len(svcs), ShouldEqual, 2)
+			So(svcs, ShouldContain, "borg-shopify-test-1")
+			So(svcs, ShouldContain, "borg-shopify-test-2")
 		})
 	})
 
-	Convey("Enumerating services", t, func() {
+	Convey("Enumerating services in another service directory", t, func() {
 		defer func() {
 			globServices = filepath.Glob
 		}()
+		var globbed string
 		globServices = func(a string) ([]string, error) {
-			return []string{"/etc/service/borg-shopify-test-1", "/etc/service/borg-shopify-test-2"}, nil
+			globbed = a
+			return []string{"/home/app/service/worker-1"}, nil
 		}
-		svcs, err := getServices("borg-shopify-*")
+		svcs, err := getServices("/home/app/service", []string{"worker-*"}, nil)
 
-		Convey("Should generate corrcect service names", func() {
+		Convey("Should glob within that directory", func() {
 			So(err, ShouldBeNil)
-			So(len(svcs), ShouldEqual, 2)
-			So(svcs, ShouldContain, "borg-shopify-test-1")
-			So(svcs, ShouldContain, "borg-shopify-test-2")
+			So(globbed, ShouldEqual, "/home/app/service/worker-*")
+			So(svcs, ShouldResemble, []string{"worker-1"})
 		})
 	})
 
-	Convey("Choosing canaries", t, func() {
-		Convey("should not choose any if ratio = 0", func() {
-			c, nc := chooseCanaries([]string{"a", "b", "c", "d"}, 0)
-			So(len(c), ShouldEqual, 0)
-			So(len(nc), ShouldEqual, 4)
+	Convey("Enumerating services with several patterns", t, func() {
+		defer func() {
+			globServices = filepath.Glob
+		}()
+		all := []string{"borg-shopify-1", "borg-shopify-2", "borg-shopify-cron-1", "borg-other-1", "other"}
+		globServices = func(a string) ([]string, error) {
+			var rets []string
+			for _, svc := range all {
+				if ok, _ := filepath.Match(a, "/etc/service/"+svc); ok {
+					rets = append(rets, "/etc/service/"+svc)
+				}
+			}
+			return rets, nil
+		}
+
+		Convey("Should deduplicate services matched by more than one pattern", func() {
+			svcs, err := getServices("/etc/service", []string{"borg-shopify-*", "borg-*"}, nil)
+			So(err, ShouldBeNil)
+			So(len(svcs), ShouldEqual, 4)
+			So(svcs, ShouldNotContain, "other")
+		})
+		Convey("Should leave out excluded services", func() {
+			svcs, err := getServices("/etc/service", []string{"borg-shopify-*"}, []string{"*-cron-*"})
+			So(err, ShouldBeNil)
+			So(len(svcs), ShouldEqual, 2)
+			So(svcs, ShouldContain, "borg-shopify-1")
+			So(svcs, ShouldContain, "borg-shopify-2")
 		})
-		Convey("should choose one if ratio is small", func() {
-			c, nc := chooseCanaries([]string{"a", "b", "c", "d", "e", "f", "g"}, 0.001)
-			So(len(c), ShouldEqual, 1)
-			So(len(nc), ShouldEqual, 6)
+		Convey("Should support regular expressions", func() {
+			svcs, err := getServices("/etc/service", []string{`re:^borg-[a-z]+-\d+$`}, []string{"re:other"})
+			So(err, ShouldBeNil)
+			So(len(svcs), ShouldEqual, 2)
+			So(svcs, ShouldContain, "borg-shopify-1")
+			So(svcs, ShouldContain, "borg-shopify-2")
 		})
-		Convey("Should round up in general", func() {
-			c, nc := chooseCanaries([]string{"a", "b", "c", "d", "e"}, 0.5)
-			So(len(c), ShouldEqual, 3)
-			So(len(nc), ShouldEqual, 2)
+		Convey("Should reject invalid regular expressions", func() {
+			_, err := getServices("/etc/service", []string{"re:borg-("}, nil)
+			So(err, ShouldNotBeNil)
 		})
 	})
 
-	Convey("Deciding on permitted timeouts", t, func() {
-		Convey("should not allow any if ratio = 0", func() {
-			So(permittedTimeouts([]string{"a", "b", "c"}, 0), ShouldEqual, 0)
+	Convey("Choosing the default service directory", t, func() {
+		defer os.Setenv("SVDIR", os.Getenv("SVDIR"))
+
+		Convey("should honour $SVDIR", func() {
+			os.Setenv("SVDIR", "/home/app/service")
+			So(envSvdir(), ShouldEqual, "/home/app/service")
+		})
+		Convey("should fall back to /etc/service", func() {
+			os.Setenv("SVDIR", "")
+			So(envSvdir(), ShouldEqual, "/etc/service")
+		})
+	})
+
+	Convey("Configuring restart hooks", t, func() {
+		c := config{ChunkRatio: rollout.Ratio(1), HookTimeout: 30 * time.Second, HookFailure: "fail"}
+
+		Convey("should run no hooks unless given", func() {
+			opts := c.Options()
+			So(opts.PreRestart, ShouldBeNil)
+			So(opts.PostRestart, ShouldBeNil)
 		})
-		Convey("should allow one if ratio is small", func() {
-			So(permittedTimeouts([]string{"a", "b", "c", "d", "e", "f"}, 0.001), ShouldEqual, 1)
+		Convey("should run the commands with the hook timeout", func() {
+			c.PreRestart, c.PostRestart = "drain {service}", "enable {service}"
+			opts := c.Options()
+			So(opts.PreRestart, ShouldResemble, rollout.CommandHook{Command: "drain {service}", Timeout: 30 * time.Second})
+			So(opts.PostRestart, ShouldResemble, rollout.CommandHook{Command: "enable {service}", Timeout: 30 * time.Second})
+			So(opts.IgnoreHookFailures, ShouldBeFalse)
 		})
-		Convey("should allow all if ratio is 1", func() {
-			So(permittedTimeouts([]string{"a", "b", "c", "d", "e", "f"}, 1), ShouldEqual, 6)
+		Convey("should ignore failures if asked to", func() {
+			c.HookFailure = "ignore"
+			So(c.Options().IgnoreHookFailures, ShouldBeTrue)
 		})
 	})
 }
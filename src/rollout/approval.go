@@ -0,0 +1,51 @@
+package rollout
+
+import "context"
+
+// Approver decides whether a rollout may continue from one phase to the next.
+// Approve blocks until it does, returning ErrNotApproved if the rollout
+// shouldn't continue, or ctx's error if ctx is done first (i.e. the rollout
+// was aborted).
+type Approver interface {
+	Approve(ctx context.Context, phase, next string) error
+}
+
+// ApproverFunc adapts an ordinary function to the Approver interface.
+type ApproverFunc func(ctx context.Context, phase, next string) error
+
+// Approve calls f.
+func (f ApproverFunc) Approve(ctx context.Context, phase, next string) error {
+	return f(ctx, phase, next)
+}
+
+// awaitApproval holds the deployment between phase p and the next until the
+// approver lets it continue.
+func (d *Deployment) awaitApproval(p, next *deploymentPhase) error {
+	if d.approver == nil {
+		return nil
+	}
+	d.emit(Event{Event: EventAwaitingApproval, Phase: p.Name})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := d.approver.Approve(ctx, p.Name, next.Name)
+	switch {
+	case err == nil:
+		d.emit(Event{Event: EventApproved, Phase: p.Name})
+	case d.aborted():
+		return d.finishAborted()
+	case err == ErrNotApproved:
+		// let restarts still in progress finish, but start no more.
+		d.Abort()
+		d.workers.Wait()
+		d.logf("phase %s not approved, stopping the rollout", p.Name)
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package rollout
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApproval(t *testing.T) {
+
+	Convey("A deployment with an approval gate", t, func() {
+		c := config{CanaryRatio: Count(1), ChunkRatio: Ratio(1), Timeout: 1}
+		var restarts int32
+		c.restartSvr = func(svr *SvRestarter) error {
+			atomic.AddInt32(&restarts, 1)
+			return nil
+		}
+		depl := NewDeployment([]string{"a", "b", "c"}, c.options())
+
+		Convey("should ask before each phase after the first", func() {
+			var asked []string
+			depl.approver = ApproverFunc(func(ctx context.Context, phase, next string) error {
+				asked = append(asked, phase+" -> "+next)
+				return nil
+			})
+			So(depl.Run(context.Background()), ShouldBeNil)
+			So(asked, ShouldResemble, []string{"canary -> main"})
+			So(atomic.LoadInt32(&restarts), ShouldEqual, 3)
+		})
+
+		Convey("should stop if not approved", func() {
+			depl.approver = ApproverFunc(func(ctx context.Context, phase, next string) error {
+				return ErrNotApproved
+			})
+			So(depl.Run(context.Background()), ShouldEqual, ErrNotApproved)
+			So(atomic.LoadInt32(&restarts), ShouldEqual, 1)
+		})
+
+		Convey("should stop waiting when cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			depl.approver = ApproverFunc(func(ctx context.Context, phase, next string) error {
+				cancel()
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			So(depl.Run(ctx), ShouldEqual, ErrAborted)
+			So(atomic.LoadInt32(&restarts), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,69 @@
+package rollout
+
+import (
+	"fmt"
+	"time"
+)
+
+// bakePollInterval is how often services are re-checked while a phase bakes.
+var bakePollInterval = time.Second
+
+// bake waits for the phase's bake time before the next phase begins,
+// re-checking every service the phase restarted successfully: each must stay
+// up, without runit restarting it, and keep passing its health check, if any.
+// It returns ErrBakeFailed for the first service that doesn't.
+func (d *Deployment) bake(p *deploymentPhase) error {
+	if p.bake <= 0 {
+		return nil
+	}
+	services := d.succeededIn(p)
+	d.emit(Event{Event: EventBakeStarted, Phase: p.Name, PhaseServices: len(services), Duration: p.bake.Seconds()})
+
+	svdir := d.svdir
+	initial := make(map[string]superviseStatus)
+	for _, svc := range services {
+		st, err := d.opts.serviceStatus(svdir, svc)
+		if err != nil {
+			return d.bakeFailed(p, svc, err.Error())
+		}
+		initial[svc] = st
+	}
+
+	deadline := time.Now().Add(p.bake)
+	for {
+		for _, svc := range services {
+			st, err := d.opts.serviceStatus(svdir, svc)
+			switch {
+			case err != nil:
+				return d.bakeFailed(p, svc, err.Error())
+			case !st.Up():
+				return d.bakeFailed(p, svc, "service went down")
+			case st.Pid != initial[svc].Pid:
+				return d.bakeFailed(p, svc, fmt.Sprintf("pid changed from %d to %d", initial[svc].Pid, st.Pid))
+			}
+			if hc := d.healthCheckFor(svc); hc != nil {
+				if err := hc.Probe.Check(svc, hc.Timeout); err != nil {
+					return d.bakeFailed(p, svc, "health check failed: "+err.Error())
+				}
+			}
+		}
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return nil
+		}
+		if remaining > bakePollInterval {
+			remaining = bakePollInterval
+		}
+		select {
+		case <-time.After(remaining):
+		case <-d.abort:
+			return d.finishAborted()
+		}
+	}
+}
+
+func (d *Deployment) bakeFailed(p *deploymentPhase, service, message string) error {
+	err := ErrBakeFailed{Service: service, Message: message}
+	d.emit(Event{Event: EventBakeFailed, Phase: p.Name, Service: service, Error: err.Error()})
+	return err
+}
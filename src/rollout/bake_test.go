@@ -0,0 +1,105 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type probeFunc func(service string, timeout time.Duration) error
+
+func (f probeFunc) Check(service string, timeout time.Duration) error {
+	return f(service, timeout)
+}
+
+func TestBake(t *testing.T) {
+
+	Convey("Baking a phase", t, func() {
+		defer func() { bakePollInterval = time.Second }()
+		bakePollInterval = 5 * time.Millisecond
+
+		c := config{
+			CanaryRatio: Count(1),
+			ChunkRatio:  Ratio(1),
+			Timeout:     1,
+			Bake:        20 * time.Millisecond,
+		}
+		var (
+			mutex    sync.Mutex
+			pids     = map[string]int{"a": 10, "b": 20, "c": 30}
+			checks   int32
+			restarts int32
+		)
+		c.serviceStatus = func(svdir, service string) (superviseStatus, error) {
+			atomic.AddInt32(&checks, 1)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if pids[service] == 0 {
+				return superviseStatus{State: stateDown}, nil
+			}
+			return superviseStatus{State: stateRun, Pid: pids[service]}, nil
+		}
+		c.restartSvr = func(svr *SvRestarter) error {
+			atomic.AddInt32(&restarts, 1)
+			return nil
+		}
+		depl := NewDeployment([]string{"a", "b", "c"}, c.options())
+
+		Convey("should wait, re-checking the canaries, before the next phase", func() {
+			t1 := time.Now()
+			So(depl.Run(context.Background()), ShouldBeNil)
+			So(time.Since(t1), ShouldBeGreaterThanOrEqualTo, 20*time.Millisecond)
+			So(atomic.LoadInt32(&checks), ShouldBeGreaterThan, 2)
+			So(atomic.LoadInt32(&restarts), ShouldEqual, 3)
+		})
+
+		Convey("should fail if a canary is restarted by runit", func() {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				mutex.Lock()
+				pids["a"] = 11
+				mutex.Unlock()
+			}()
+			err := depl.Run(context.Background())
+			So(err, ShouldHaveSameTypeAs, ErrBakeFailed{})
+			So(err.Error(), ShouldContainSubstring, "pid changed from 10 to 11")
+			So(atomic.LoadInt32(&restarts), ShouldEqual, 1)
+		})
+
+		Convey("should fail if a canary goes down", func() {
+			pids["a"] = 0
+			So(depl.Run(context.Background()), ShouldHaveSameTypeAs, ErrBakeFailed{})
+		})
+
+		Convey("should fail if a canary fails its health check", func() {
+			depl.healthCheck = &HealthCheck{Probe: probeFunc(func(service string, timeout time.Duration) error {
+				return errors.New("503 Service Unavailable")
+			})}
+			err := depl.Run(context.Background())
+			So(err, ShouldHaveSameTypeAs, ErrBakeFailed{})
+			So(err.Error(), ShouldContainSubstring, "health check failed: 503 Service Unavailable")
+		})
+
+		Convey("should only re-check services that restarted successfully", func() {
+			c.CanaryTimeoutTolerance = Ratio(1)
+			c.TimeoutTolerance = Ratio(1)
+			c.restartSvr = alwaysTimeout
+			depl := NewDeployment([]string{"a", "b", "c"}, c.options())
+			pids["a"] = 0
+			So(depl.Run(context.Background()), ShouldBeNil)
+			So(depl.succeededIn(depl.phases[0]), ShouldBeEmpty)
+		})
+
+		Convey("should not bake after the last phase", func() {
+			c.CanaryRatio = Ratio(0)
+			depl := NewDeployment([]string{"a", "b", "c"}, c.options())
+			So(depl.Run(context.Background()), ShouldBeNil)
+			So(atomic.LoadInt32(&checks), ShouldEqual, 0)
+		})
+	})
+}
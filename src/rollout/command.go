@@ -0,0 +1,99 @@
+package rollout
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errCommandTimeout means that a command was killed for running too long.
+type errCommandTimeout struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (e errCommandTimeout) Error() string {
+	return fmt.Sprintf("%s: no result after %s", e.Command, e.Timeout)
+}
+
+// running holds the process groups of the commands being run by RunCommand.
+var running = struct {
+	sync.Mutex
+	pgids map[int]bool
+}{pgids: make(map[int]bool)}
+
+// KillCommands kills every command RunCommand is running, along with any
+// children, e.g. before exiting without waiting for them to finish.
+func KillCommands() {
+	running.Lock()
+	defer running.Unlock()
+	for pgid := range running.pgids {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+}
+
+// RunCommand runs command via `sh -c`, as hooks and command probes are run,
+// with env added to its environment. If it's still running after timeout, it's
+// killed, along with any children, and errCommandTimeout is returned. A zero
+// timeout means no limit. It's killed the same way once ctx is done, returning
+// ctx's error. Errors include the command's output.
+func RunCommand(ctx context.Context, command string, env []string, timeout time.Duration) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// Run in its own process group so that a hung command can be killed along
+	// with any children holding its output open.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pgid := cmd.Process.Pid
+	running.Lock()
+	running.pgids[pgid] = true
+	running.Unlock()
+	defer func() {
+		running.Lock()
+		delete(running.pgids, pgid)
+		running.Unlock()
+	}()
+
+	timedOut := make(chan struct{})
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			close(timedOut)
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+		defer timer.Stop()
+	}
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-exited:
+		}
+	}()
+	err := cmd.Wait()
+
+	select {
+	case <-timedOut:
+		return errCommandTimeout{Command: command, Timeout: timeout}
+	default:
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %s", command, ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s: %s", command, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,636 @@
+/*
+Package rollout restarts multiple runit services concurrently, in phases, with
+configurable tolerance for timeouts and failures. It's the engine behind the
+sv-rollout command, for tools that would rather embed it than shell out:
+
+	d := rollout.NewDeployment(services, rollout.Options{
+		Phases:  rollout.CanaryPhases(rollout.Phase{Ratio: rollout.Count(1)}, rollout.Phase{ChunkRatio: rollout.Ratio(0.2)}),
+		Timeout: 90 * time.Second,
+		OnEvent: func(e rollout.Event) { log.Println(e.Event, e.Service) },
+	})
+	err := d.Run(ctx)
+*/
+package rollout
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultSvdir is the runit service directory used unless Options.Svdir says
+// otherwise.
+const DefaultSvdir = "/etc/service"
+
+// Options configures a Deployment.
+type Options struct {
+	// Phases are restarted in order. The last phase restarts every service not
+	// claimed by an earlier one.
+	Phases []Phase
+	// Canaries, if set, are restarted first, whatever their priority, and the
+	// first of several phases is enlarged to hold them if need be.
+	Canaries []string
+	// Svdir is the directory containing the services. Defaults to DefaultSvdir.
+	Svdir string
+	// Timeout is how long to wait for each service to restart.
+	Timeout time.Duration
+	// Restarter restarts each service. Defaults to Runit{Svdir}.
+	Restarter Restarter
+
+	// HealthCheck, if set, must pass before a restart counts as successful.
+	HealthCheck *HealthCheck
+	// StableFor, if set, is how long a service must stay up after restarting
+	// before the restart counts as successful.
+	StableFor time.Duration
+	// Bake is how long to re-check each phase's services before the next phase
+	// begins, for phases that don't set their own.
+	Bake time.Duration
+	// Approver, if set, must approve each phase before the next begins.
+	Approver Approver
+
+	// PreRestart and PostRestart, if set, are run before and after each
+	// restart. A failed hook fails the restart unless IgnoreHookFailures is
+	// set, in which case it's only logged.
+	PreRestart         Hook
+	PostRestart        Hook
+	IgnoreHookFailures bool
+	// Overrides replace the settings above for individual services, by name.
+	Overrides map[string]Overrides
+
+	// MaxStartsPerMinute, if set, limits the rate at which restarts are
+	// started, allowing StartBurst of them at once.
+	MaxStartsPerMinute float64
+	StartBurst         int
+	// MinStartInterval, if set, is the least time between the starts of
+	// consecutive restarts.
+	MinStartInterval time.Duration
+
+	// OnEvent, if set, is called with every Event as the rollout progresses.
+	// It's called from several goroutines, so must be safe for concurrent use.
+	OnEvent func(Event)
+	// Statsd, if set, is sent the duration of every restart, phase and the
+	// rollout, the rollout's counts and the canaries' verdict, and an event at
+	// the rollout's start and finish.
+	Statsd StatsdClient
+	// Logger is used for messages that aren't Events. Defaults to the standard
+	// logger.
+	Logger *log.Logger
+	// Verbose logs the rollout's decisions as they're made.
+	Verbose bool
+
+	// restartSvr restarts each service, and serviceStatus reads a service's
+	// runit status while it proves stable or its phase bakes. They default to
+	// SvRestarter.Restart and serviceStatus, and are replaced in tests.
+	restartSvr    func(*SvRestarter) error
+	serviceStatus func(svdir, service string) (superviseStatus, error)
+}
+
+// Deployment orchestrates the concurrent restarting of all the indicated
+// services, ultimately returning an error indicating whether it was
+// successful.
+type Deployment struct {
+	numServices int
+
+	phases []*deploymentPhase
+	phase  *deploymentPhase
+
+	opts        Options
+	svdir       string
+	timeout     time.Duration
+	restarter   Restarter
+	healthCheck *HealthCheck
+	stableFor   time.Duration
+	index       int
+
+	currentFailuresPermitted int
+	currentTimeoutsPermitted int
+
+	successesSoFar int
+	timeoutsSoFar  int
+	failuresSoFar  int
+
+	svrs []*SvRestarter
+	// services restarted by an earlier run of a resumed rollout.
+	skip map[string]bool
+	// services whose restarts have been started, and those that succeeded,
+	// guarded by startedMutex.
+	started      map[string]bool
+	succeeded    map[string]bool
+	startedMutex sync.Mutex
+	// rollback is set for a Deployment returned by NewRollback.
+	rollback bool
+
+	tally *tally
+
+	toRestart chan *SvRestarter
+	results   chan error
+	// outstanding is the number of restarts queued whose results are yet to
+	// be read from results.
+	outstanding int
+
+	workers sync.WaitGroup
+	abort   chan struct{}
+	limiter *startLimiter
+
+	// approver, if set, must approve each phase before the next begins.
+	approver Approver
+}
+
+// deploymentPhase is a Phase resolved against the services actually being
+// restarted. The permitted counts are cumulative, including every earlier
+// phase, since that's how Deployment tracks timeouts and failures.
+type deploymentPhase struct {
+	Phase
+	services []string
+
+	concurrency       int
+	timeoutsPermitted int
+	failuresPermitted int
+
+	// successes required (across all phases so far) before the next phase may
+	// begin.
+	mustPass int
+	// bake is how long to re-check the phase's services before the next phase.
+	bake time.Duration
+}
+
+// NewDeployment initializes a Deployment object with a list of services
+// (entries in the service directory) and the options to restart them with.
+func NewDeployment(services []string, opts Options) *Deployment {
+	var d Deployment
+	d.numServices = len(services)
+	services, pinned := pinCanaries(prioritize(services, opts.Overrides), opts.Canaries)
+
+	var (
+		remaining         = services
+		phases            = opts.Phases
+		servicesSoFar     int
+		timeoutsPermitted int
+		failuresPermitted int
+	)
+	for i, p := range phases {
+		dp := &deploymentPhase{Phase: p}
+		if i == len(phases)-1 {
+			dp.services, remaining = remaining, nil
+		} else {
+			n := p.Ratio.Of(len(services))
+			if i == 0 && n < pinned {
+				n = pinned
+			}
+			dp.services, remaining = splitServices(remaining, n)
+		}
+		servicesSoFar += len(dp.services)
+		timeoutsPermitted += permittedTimeouts(dp.services, p.TimeoutTolerance)
+		failuresPermitted += permittedFailures(dp.services, p.FailureTolerance, p.FailureCount)
+
+		dp.concurrency = p.ChunkRatio.Of(len(dp.services))
+		if dp.concurrency < 1 {
+			dp.concurrency = 1
+		}
+		dp.timeoutsPermitted = timeoutsPermitted
+		dp.failuresPermitted = failuresPermitted
+		dp.mustPass = servicesSoFar - timeoutsPermitted - failuresPermitted
+		dp.bake = p.Bake
+		if dp.bake == 0 {
+			dp.bake = opts.Bake
+		}
+		d.phases = append(d.phases, dp)
+	}
+
+	if opts.restartSvr == nil {
+		opts.restartSvr = (*SvRestarter).Restart
+	}
+	if opts.serviceStatus == nil {
+		opts.serviceStatus = serviceStatus
+	}
+	d.opts = opts
+	d.svdir = opts.Svdir
+	if d.svdir == "" {
+		d.svdir = DefaultSvdir
+	}
+	d.timeout = opts.Timeout
+	d.restarter = opts.Restarter
+	if d.restarter == nil {
+		d.restarter = Runit{Svdir: d.svdir}
+	}
+	d.healthCheck = opts.HealthCheck
+	d.stableFor = opts.StableFor
+
+	d.results = make(chan error, 1024)
+	d.tally = &tally{}
+	d.abort = make(chan struct{})
+	d.started = make(map[string]bool)
+	d.succeeded = make(map[string]bool)
+	d.approver = opts.Approver
+	d.limiter = newStartLimiter(opts.MaxStartsPerMinute, opts.StartBurst, opts.MinStartInterval)
+	if opts.Verbose {
+		d.limiter.debugf = d.debugf
+	}
+
+	for _, p := range d.phases {
+		d.debugf("phase %s: services: %v", p.Name, p.services)
+		d.debugf("phase %s: concurrency: %d", p.Name, p.concurrency)
+		d.debugf("phase %s: total timeouts permitted: %d", p.Name, p.timeoutsPermitted)
+		d.debugf("phase %s: total failures permitted: %d", p.Name, p.failuresPermitted)
+	}
+
+	return &d
+}
+
+// Resume marks services as already restarted successfully by an earlier,
+// interrupted run of the same rollout, so that Run skips them. They count
+// towards each phase's successes as though they'd been restarted again. It
+// returns the number of services that will be skipped.
+func (d *Deployment) Resume(succeeded map[string]bool) int {
+	d.skip = make(map[string]bool)
+	for _, p := range d.phases {
+		for _, svc := range p.services {
+			if succeeded[svc] {
+				d.skip[svc] = true
+			}
+		}
+	}
+	d.successesSoFar += len(d.skip)
+	d.tally.counts.Successes += len(d.skip)
+	return len(d.skip)
+}
+
+// Run does all the actual grunt work of concurrently restarting the services.
+// It restarts each phase in turn, with the concurrency indicated by the
+// phase's ChunkRatio. Once a sufficient number of a phase's services pass, it
+// moves on to the next phase. Cancelling ctx aborts the deployment, as Abort
+// does.
+func (d *Deployment) Run(ctx context.Context) (err error) {
+	defer d.abortWhenDone(ctx)()
+	start := time.Now()
+	d.emit(Event{Event: EventRolloutStarted})
+	d.sendRolloutStarted()
+	defer func() {
+		if err != nil {
+			// stop restarts still queued or in flight, so that none happen
+			// after Run returns.
+			d.Abort()
+			d.workers.Wait()
+		}
+		d.sendRolloutFinished(time.Since(start), err)
+		e := Event{Event: EventRolloutFinished, Duration: time.Since(start).Seconds()}
+		counts := d.tally.snapshot()
+		e.Counts = &counts
+		if err != nil {
+			e.Error = err.Error()
+		}
+		d.emit(e)
+	}()
+
+	for i, p := range d.phases {
+		done := p.successOK(d)
+		if i == len(d.phases)-1 {
+			done = d.allComplete
+		}
+		if d.aborted() {
+			return d.finishAborted()
+		}
+		d.phase = p
+		if len(p.services) > 0 {
+			d.emit(Event{Event: EventPhaseStarted, Phase: p.Name, PhaseServices: len(p.services)})
+		}
+		phaseStart := time.Now()
+		err = d.restartServices(p.services, p.concurrency, p.failuresPermitted, p.timeoutsPermitted, done)
+		if err == nil && i < len(d.phases)-1 && len(p.services) > 0 {
+			err = d.bake(p)
+		}
+		if len(p.services) > 0 {
+			d.sendPhaseFinished(i, p, time.Since(phaseStart), err)
+		}
+		if err != nil {
+			return
+		}
+		if i < len(d.phases)-1 && len(p.services) > 0 {
+			if err = d.awaitApproval(p, d.phases[i+1]); err != nil {
+				return
+			}
+		}
+	}
+	return d.awaitOutstanding()
+}
+
+// Abort stops the deployment from starting any more restarts. Run waits for
+// the restarts already in progress to finish, then returns ErrAborted.
+func (d *Deployment) Abort() {
+	select {
+	case <-d.abort:
+	default:
+		close(d.abort)
+	}
+}
+
+// abortWhenDone aborts the deployment once ctx is done. The returned function
+// stops watching ctx.
+func (d *Deployment) abortWhenDone(ctx context.Context) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.Abort()
+		case <-stopped:
+		}
+	}()
+	return func() { close(stopped) }
+}
+
+func (d *Deployment) aborted() bool {
+	select {
+	case <-d.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// finishAborted waits for in-progress restarts to finish. What was done
+// before the abort is reported by the EventRolloutFinished event's Counts.
+func (d *Deployment) finishAborted() error {
+	d.workers.Wait()
+	return ErrAborted
+}
+
+// Restarted returns the services whose restarts were started, in this run or
+// (when resuming) an earlier one, in the order they were planned.
+func (d *Deployment) Restarted() (services []string) {
+	d.startedMutex.Lock()
+	defer d.startedMutex.Unlock()
+	for _, p := range d.phases {
+		for _, svc := range p.services {
+			if d.started[svc] || d.skip[svc] {
+				services = append(services, svc)
+			}
+		}
+	}
+	return
+}
+
+// succeededIn returns the phase's services that have restarted successfully,
+// in this run or (when resuming) an earlier one.
+func (d *Deployment) succeededIn(p *deploymentPhase) (services []string) {
+	d.startedMutex.Lock()
+	defer d.startedMutex.Unlock()
+	for _, svc := range p.services {
+		if d.succeeded[svc] || d.skip[svc] {
+			services = append(services, svc)
+		}
+	}
+	return
+}
+
+func (d *Deployment) emit(e Event) {
+	e.Time = time.Now()
+	e.Services = d.numServices
+	if d.opts.OnEvent != nil {
+		d.opts.OnEvent(e)
+	}
+}
+
+func (d *Deployment) logf(format string, v ...interface{}) {
+	if d.opts.Logger != nil {
+		d.opts.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+// debugf logs in verbose mode only.
+func (d *Deployment) debugf(format string, v ...interface{}) {
+	if d.opts.Verbose {
+		d.logf("[debug] "+format, v...)
+	}
+}
+
+func (d *Deployment) startWorkers(n int, queue <-chan *SvRestarter) {
+	d.workers.Add(n)
+	for i := 0; i < n; i++ {
+		go d.startWorker(queue)
+	}
+}
+
+func (d *Deployment) startWorker(queue <-chan *SvRestarter) {
+	defer d.workers.Done()
+	for svr := range queue {
+		if d.aborted() || !d.limiter.wait(d.abort) {
+			// drain the queue without restarting anything else.
+			continue
+		}
+		d.startedMutex.Lock()
+		d.started[svr.Service] = true
+		d.startedMutex.Unlock()
+		err := d.opts.restartSvr(svr)
+		if err == nil {
+			d.startedMutex.Lock()
+			d.succeeded[svr.Service] = true
+			d.startedMutex.Unlock()
+		}
+		d.results <- err
+	}
+}
+
+func (d *Deployment) restartServices(services []string, concurrency, failuresPermitted, timeoutsPermitted int, done func() bool) (err error) {
+	d.currentFailuresPermitted = failuresPermitted
+	d.currentTimeoutsPermitted = timeoutsPermitted
+
+	if len(services) == 0 {
+		return nil
+	}
+
+	// Don't restart services when a lock is present
+	if _, err := os.Stat("/var/lock/dont-sv-rollout"); err == nil {
+		d.logf("/var/lock/dont-sv-rollout present, not restarting services")
+		return nil
+	}
+
+	d.toRestart = make(chan *SvRestarter, len(services))
+	remaining := 0 // number of services yet to be processed.
+	for _, svc := range services {
+		d.index++
+		if d.skip[svc] {
+			continue
+		}
+		svr := NewSvRestarter(svc, d.numServices, d.index, d.timeoutFor(svc))
+		svr.svdir = d.svdir
+		svr.restarter = d.restarter
+		svr.healthCheck = d.healthCheckFor(svc)
+		svr.stableFor = d.stableFor
+		svr.status = d.opts.serviceStatus
+		svr.preRestart, svr.postRestart = d.hooksFor(svc)
+		svr.ignoreHookFailures = d.opts.IgnoreHookFailures
+		svr.phase = d.phase.Name
+		svr.tally = d.tally
+		svr.report = d.opts.OnEvent
+		svr.statsd = d.opts.Statsd
+		svr.logf = d.logf
+		d.svrs = append(d.svrs, svr)
+		d.toRestart <- svr
+		remaining++
+	}
+	close(d.toRestart)
+	if remaining == 0 {
+		return nil
+	}
+	d.startWorkers(concurrency, d.toRestart)
+	d.outstanding += remaining
+
+	for {
+		var result error
+		select {
+		case result = <-d.results:
+			d.outstanding--
+		case <-d.abort:
+			return d.finishAborted()
+		}
+		if err = d.count(result); err != nil {
+			return
+		}
+		if done() {
+			return nil
+		}
+
+		// a rollback restarts every service however many time out.
+		if !d.rollback && d.canPreempt(remaining) {
+			for _, svr := range d.svrs {
+				svr.Preempt()
+			}
+		}
+		remaining--
+	}
+}
+
+// count counts the result of a restart against the tolerances.
+func (d *Deployment) count(result error) error {
+	switch result.(type) {
+	case nil:
+		d.successesSoFar++
+	case ErrRestartFailed, ErrRestartUnstable:
+		return d.incrementFailures()
+	case ErrRestartTimeout:
+		return d.incrementTimeouts()
+	case ErrRestartPreempted:
+		// no need to handle the error here because we pre-verified that it's ok
+		// before preempting the svr
+		_ = d.incrementTimeouts()
+	default:
+		panic(result)
+	}
+	return nil
+}
+
+// awaitOutstanding waits, once the last phase is done, for the restarts that
+// earlier phases moved on from without waiting for, counting their results as
+// they come. The last phase may not have waited for them either, if it had
+// nothing to restart.
+func (d *Deployment) awaitOutstanding() error {
+	for d.outstanding > 0 {
+		select {
+		case result := <-d.results:
+			d.outstanding--
+			if err := d.count(result); err != nil {
+				return err
+			}
+		case <-d.abort:
+			return d.finishAborted()
+		}
+	}
+	d.workers.Wait()
+	return nil
+}
+
+// canPreempt reports whether the deployment would still succeed if every
+// remaining restart timed out, in which case there's no need to wait for them.
+// Preempted restarts count as timeouts, even those that go on to fail (see
+// SvRestarter.Restart), so only the timeouts still permitted matter.
+func (d *Deployment) canPreempt(remaining int) bool {
+	return d.currentTimeoutsPermitted-d.timeoutsSoFar >= remaining
+}
+
+func (d *Deployment) incrementFailures() error {
+	d.failuresSoFar++
+	if d.failuresSoFar > d.currentFailuresPermitted {
+		return ErrTooManyFailures
+	}
+	return nil
+}
+
+func (d *Deployment) incrementTimeouts() error {
+	d.timeoutsSoFar++
+	if d.timeoutsSoFar > d.currentTimeoutsPermitted {
+		return ErrTooManyTimeouts
+	}
+	return nil
+}
+
+// successOK returns a function reporting whether enough services have
+// restarted successfully for the deployment to move past this phase.
+func (p *deploymentPhase) successOK(d *Deployment) func() bool {
+	return func() bool {
+		return d.successesSoFar >= p.mustPass
+	}
+}
+
+func (d *Deployment) allComplete() bool {
+	done := d.successesSoFar + d.timeoutsSoFar + d.failuresSoFar
+	return done == d.numServices
+}
+
+func chooseCanaries(services []string, ratio Quantity) (canaries []string, nonCanaries []string) {
+	return splitServices(services, ratio.Of(len(services)))
+}
+
+// pinCanaries moves those of canaries found in services to the front, in the
+// order given, returning how many were found.
+func pinCanaries(services, canaries []string) (ordered []string, n int) {
+	if len(canaries) == 0 {
+		return services, 0
+	}
+	remaining := make(map[string]bool)
+	for _, svc := range services {
+		remaining[svc] = true
+	}
+	for _, svc := range canaries {
+		if remaining[svc] {
+			ordered = append(ordered, svc)
+			delete(remaining, svc)
+		}
+	}
+	n = len(ordered)
+	for _, svc := range services {
+		if remaining[svc] {
+			ordered = append(ordered, svc)
+		}
+	}
+	return ordered, n
+}
+
+// splitServices returns the first n services, and the rest.
+func splitServices(services []string, n int) (head []string, tail []string) {
+	for index, service := range services {
+		if index < n {
+			head = append(head, service)
+		} else {
+			tail = append(tail, service)
+		}
+	}
+	return
+}
+
+func permittedTimeouts(services []string, tolerance Quantity) int {
+	return tolerance.Of(len(services))
+}
+
+// permittedFailures returns the greater of the number of failures that the
+// tolerance permits and the absolute count.
+func permittedFailures(services []string, tolerance Quantity, count int) int {
+	n := tolerance.Of(len(services))
+	if count > n {
+		return count
+	}
+	return n
+}
@@ -0,0 +1,684 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	concurrency int32
+)
+
+// config holds the canary and chunk settings most of these tests are written
+// against, as sv-rollout's flags give them.
+type config struct {
+	CanaryRatio            Quantity
+	CanaryTimeoutTolerance Quantity
+	ChunkRatio             Quantity
+	TimeoutTolerance       Quantity
+	CanaryFailureTolerance Quantity
+	FailureTolerance       Quantity
+	CanaryFailureCount     int
+	FailureCount           int
+	ExplicitPhases         []Phase
+	// Timeout is in seconds.
+	Timeout          int
+	Bake             time.Duration
+	MinStartInterval time.Duration
+	// restartSvr and serviceStatus, if set, replace SvRestarter.Restart and
+	// reading services' runit status.
+	restartSvr    func(*SvRestarter) error
+	serviceStatus func(svdir, service string) (superviseStatus, error)
+}
+
+func (c config) options() Options {
+	phases := c.ExplicitPhases
+	if len(phases) == 0 {
+		phases = CanaryPhases(
+			Phase{Ratio: c.CanaryRatio, TimeoutTolerance: c.CanaryTimeoutTolerance, FailureTolerance: c.CanaryFailureTolerance, FailureCount: c.CanaryFailureCount},
+			Phase{ChunkRatio: c.ChunkRatio, TimeoutTolerance: c.TimeoutTolerance, FailureTolerance: c.FailureTolerance, FailureCount: c.FailureCount},
+		)
+	}
+	return Options{
+		Phases:           phases,
+		Timeout:          time.Duration(c.Timeout) * time.Second,
+		Bake:             c.Bake,
+		MinStartInterval: c.MinStartInterval,
+		restartSvr:       c.restartSvr,
+		serviceStatus:    c.serviceStatus,
+	}
+}
+
+// eventRecorder collects a Deployment's events, from any goroutine.
+type eventRecorder struct {
+	sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) record(e Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, e)
+}
+
+// services lists the events for each service, as "service event".
+func (r *eventRecorder) services() []string {
+	r.Lock()
+	defer r.Unlock()
+	var lines []string
+	for _, e := range r.events {
+		if e.Service != "" {
+			lines = append(lines, e.Service+" "+e.Event)
+		}
+	}
+	return lines
+}
+
+var (
+	quantum = 25 * time.Millisecond
+)
+
+func restartWithTiming(svr *SvRestarter) error {
+	atomic.AddInt32(&concurrency, 1)
+	time.Sleep(2 * quantum)
+	atomic.AddInt32(&concurrency, -1)
+
+	return nil
+}
+
+func alwaysPass(svr *SvRestarter) error {
+	return nil
+}
+
+func alwaysTimeout(svr *SvRestarter) error {
+	return ErrRestartTimeout{Service: svr.Service}
+}
+
+func alwaysFail(svr *SvRestarter) error {
+	return ErrRestartFailed{Service: svr.Service, Message: "failed"}
+}
+
+func alwaysUnstable(svr *SvRestarter) error {
+	return ErrRestartUnstable{Service: svr.Service, Message: "pid changed"}
+}
+
+func timeoutOneService(svr *SvRestarter) error {
+	if svr.Service == "b" {
+		return alwaysTimeout(svr)
+	}
+	return alwaysPass(svr)
+}
+
+func failOneService(svr *SvRestarter) error {
+	if svr.Service == "b" {
+		return alwaysFail(svr)
+	}
+	return alwaysPass(svr)
+}
+
+func TestDeployment(t *testing.T) {
+
+	config := config{
+		CanaryRatio:            Ratio(0.0001),
+		CanaryTimeoutTolerance: Ratio(0),
+		ChunkRatio:             Ratio(0.2),
+		TimeoutTolerance:       Ratio(0),
+		Timeout:                1,
+	}
+
+	Convey("Running a deployment", t, func() {
+
+		Convey("with preemption", func() {
+			config.CanaryRatio = Ratio(0)
+			config.TimeoutTolerance = Ratio(0.61)
+			config.ChunkRatio = Ratio(0.001)
+			config.restartSvr = nil
+
+			var events eventRecorder
+			opts := config.options()
+			opts.Restarter = RestarterFunc(func(s string, t time.Duration, a chan<- struct{}) error {
+				close(a)
+				time.Sleep(250 * time.Millisecond)
+				return nil
+			})
+			opts.OnEvent = events.record
+
+			depl := NewDeployment([]string{"a", "b", "c", "d", "e"}, opts)
+			t1 := time.Now()
+			err := depl.Run(context.Background())
+			t2 := time.Since(t1)
+
+			Convey("succeeds, faster than without preemption", func() {
+				// tolerance = 60%, therefore only need to wait on 2/5.
+				// 2 * 250ms = 500ms, but will take usually ~510-520ms.
+				So(t2, ShouldBeBetween, 500*time.Millisecond, 599*time.Millisecond)
+				So(err, ShouldBeNil)
+
+				So(events.services(), ShouldResemble, []string{
+					"a restarting",
+					"a succeeded",
+					"b restarting",
+					"b succeeded",
+					"c restarting",
+					"c preempted",
+					"d restarting",
+					"d preempted",
+					"e restarting",
+					"e preempted",
+				})
+			})
+		})
+
+		Convey("with no canaries and 50% timeouts allowed", func() {
+			config.CanaryRatio = Ratio(0)
+			config.TimeoutTolerance = Ratio(0.5)
+			config.ChunkRatio = Ratio(0.001)
+			Convey("succeeds when everything restarts successfully", func() {
+				config.restartSvr = alwaysPass
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				err := depl.Run(context.Background())
+				So(err, ShouldBeNil)
+			})
+			Convey("fails when everything times out", func() {
+				config.restartSvr = alwaysTimeout
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				err := depl.Run(context.Background())
+				So(err, ShouldEqual, ErrTooManyTimeouts)
+			})
+			Convey("fails when everything fails", func() {
+				config.restartSvr = alwaysFail
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				err := depl.Run(context.Background())
+				So(err, ShouldEqual, ErrTooManyFailures)
+			})
+			Convey("fails when everything is unstable", func() {
+				config.restartSvr = alwaysUnstable
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				err := depl.Run(context.Background())
+				So(err, ShouldEqual, ErrTooManyFailures)
+			})
+			Convey("fails when only one service fails", func() {
+				config.restartSvr = failOneService
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				err := depl.Run(context.Background())
+				So(err, ShouldEqual, ErrTooManyFailures)
+			})
+			Convey("stops restarting services once it fails", func() {
+				config.ChunkRatio = Ratio(0.2)
+				var restarts int32
+				config.restartSvr = func(svr *SvRestarter) error {
+					atomic.AddInt32(&restarts, 1)
+					time.Sleep(10 * time.Millisecond)
+					return alwaysFail(svr)
+				}
+				var services []string
+				for i := 0; i < 50; i++ {
+					services = append(services, fmt.Sprint(i))
+				}
+				depl := NewDeployment(services, config.options())
+				So(depl.Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+				returned := atomic.LoadInt32(&restarts)
+				time.Sleep(100 * time.Millisecond)
+				So(atomic.LoadInt32(&restarts), ShouldEqual, returned)
+				So(returned, ShouldBeLessThan, 50)
+			})
+			Convey("reports nothing after the rollout finishes", func() {
+				config.ChunkRatio = Ratio(0.2)
+				config.restartSvr = func(svr *SvRestarter) error {
+					time.Sleep(10 * time.Millisecond)
+					svr.emit(Event{Event: EventFailed})
+					return alwaysFail(svr)
+				}
+				var services []string
+				for i := 0; i < 50; i++ {
+					services = append(services, fmt.Sprint(i))
+				}
+				var mu sync.Mutex
+				var events []string
+				opts := config.options()
+				opts.OnEvent = func(e Event) {
+					mu.Lock()
+					defer mu.Unlock()
+					events = append(events, e.Event)
+				}
+				So(NewDeployment(services, opts).Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+				time.Sleep(100 * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				So(events[len(events)-1], ShouldEqual, EventRolloutFinished)
+			})
+			Convey("succeeds when only one service times out", func() {
+				config.restartSvr = timeoutOneService
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				err := depl.Run(context.Background())
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("with failures tolerated", func() {
+			config.CanaryRatio = Ratio(0.001)
+			config.ChunkRatio = Ratio(0.001)
+			config.TimeoutTolerance = Ratio(0)
+			Reset(func() {
+				config.CanaryFailureTolerance = Ratio(0)
+				config.FailureTolerance = Ratio(0)
+				config.FailureCount = 0
+			})
+			Convey("succeeds when no more than the failure tolerance fail", func() {
+				config.FailureTolerance = Ratio(0.5)
+				config.restartSvr = failOneService
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				So(depl.currentFailuresPermitted, ShouldEqual, 0)
+				So(depl.phases[1].failuresPermitted, ShouldEqual, 1)
+				So(depl.Run(context.Background()), ShouldBeNil)
+			})
+			Convey("succeeds when no more than the failure count fail", func() {
+				config.FailureCount = 2
+				config.restartSvr = func(svr *SvRestarter) error {
+					if svr.Service == "b" || svr.Service == "c" {
+						return alwaysFail(svr)
+					}
+					return nil
+				}
+				depl := NewDeployment([]string{"a", "b", "c", "d"}, config.options())
+				depl.phases[0].services, depl.phases[1].services = []string{"a"}, []string{"b", "c", "d"}
+				So(depl.Run(context.Background()), ShouldBeNil)
+			})
+			Convey("fails when more than that fail, even if the canaries may", func() {
+				config.FailureCount = 1
+				config.restartSvr = alwaysFail
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				depl.phases[0].services, depl.phases[1].services = []string{"a"}, []string{"b", "c"}
+				config.CanaryFailureTolerance = Ratio(1)
+				depl2 := NewDeployment([]string{"a", "b", "c"}, config.options())
+				So(depl.Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+				So(depl2.Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+			})
+			Convey("doesn't preempt restarts that need waiting for after tolerated timeouts", func() {
+				config.CanaryRatio = Ratio(0)
+				config.TimeoutTolerance = Ratio(0.5)
+				config.FailureTolerance = Ratio(0.5)
+				var preempted int32
+				config.restartSvr = func(svr *SvRestarter) error {
+					if svr.Service == "a" {
+						return alwaysTimeout(svr)
+					}
+					select {
+					case <-svr.preempt:
+						atomic.AddInt32(&preempted, 1)
+						return ErrRestartPreempted{Service: svr.Service}
+					case <-time.After(quantum):
+						return nil
+					}
+				}
+				// two timeouts permitted, one used by a: b and c must each be
+				// waited for in case they both time out.
+				depl := NewDeployment([]string{"a", "b", "c", "d"}, config.options())
+				So(depl.phases[1].services, ShouldResemble, []string{"a", "b", "c", "d"})
+				So(depl.Run(context.Background()), ShouldBeNil)
+				So(atomic.LoadInt32(&preempted), ShouldEqual, 0)
+			})
+		})
+
+		Convey("with one canary followed by 100%", func() {
+			config.CanaryRatio = Ratio(0.001)
+			config.ChunkRatio = Ratio(1)
+			config.TimeoutTolerance = Ratio(0.5)
+			Convey("Fails when the canary times out", func() {
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				depl.opts.restartSvr = func(svr *SvRestarter) error {
+					if svr.Service == depl.phases[0].services[0] {
+						return alwaysTimeout(svr)
+					}
+					return nil
+				}
+				err := depl.Run(context.Background())
+				So(err, ShouldEqual, ErrTooManyTimeouts)
+			})
+			Convey("Succeeds when one non-canary service times out", func() {
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				depl.opts.restartSvr = func(svr *SvRestarter) error {
+					if svr.Service == depl.phases[1].services[0] {
+						return alwaysTimeout(svr)
+					}
+					return nil
+				}
+				err := depl.Run(context.Background())
+				So(err, ShouldBeNil)
+			})
+			Convey("Fails when the canary fails", func() {
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				depl.opts.restartSvr = func(svr *SvRestarter) error {
+					if svr.Service == depl.phases[0].services[0] {
+						return alwaysFail(svr)
+					}
+					return nil
+				}
+				err := depl.Run(context.Background())
+				So(err, ShouldEqual, ErrTooManyFailures)
+			})
+			Convey("Succeeds when one non-canary service fails", func() {
+				depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+				depl.opts.restartSvr = func(svr *SvRestarter) error {
+					if svr.Service == depl.phases[1].services[0] {
+						return alwaysFail(svr)
+					}
+					return nil
+				}
+				err := depl.Run(context.Background())
+				So(err, ShouldEqual, ErrTooManyFailures)
+			})
+		})
+
+	})
+
+	Convey("Running a deployment with canary-ratio 0.001 and chunk-ratio 0.25", t, func() {
+		config.CanaryRatio = Ratio(0.001)
+		config.ChunkRatio = Ratio(0.25)
+		Convey("on 8 nodes", func() {
+			depl := NewDeployment([]string{"a", "b", "c", "d", "e", "f", "g", "h"}, config.options())
+			depl.opts.restartSvr = restartWithTiming
+
+			Convey("should restart the canary alone, then two nodes concurrently", func() {
+				ch := make(chan error)
+				go func() {
+					ch <- depl.Run(context.Background())
+				}()
+				time.Sleep(quantum) // put us out of phase with the sleeps in the restart code
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 1)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 2)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 2)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 2)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 1)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 0)
+
+				var err error
+				select {
+				case err = <-ch:
+					So(err, ShouldBeNil)
+				default:
+					// should have been done
+					So(nil, ShouldNotBeNil)
+				}
+			})
+		})
+	})
+
+	Convey("Running a deployment with canary-ratio 0 and chunk-ratio 0.5", t, func() {
+		config.CanaryRatio = Ratio(0)
+		config.ChunkRatio = Ratio(0.5)
+		Convey("on 3 nodes", func() {
+			depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+			depl.opts.restartSvr = restartWithTiming
+
+			Convey("should restart two nodes, then the last one", func() {
+				ch := make(chan error)
+				go func() {
+					ch <- depl.Run(context.Background())
+				}()
+				time.Sleep(quantum) // put us out of phase with the sleeps in the restart code
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 2)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 1)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 0)
+
+				var err error
+				select {
+				case err = <-ch:
+					So(err, ShouldBeNil)
+				default:
+					// should have been done
+					So(nil, ShouldNotBeNil)
+				}
+			})
+		})
+
+	})
+
+	Convey("Running a deployment with explicit phases", t, func() {
+		config.ExplicitPhases = []Phase{
+			{Name: "canary", Ratio: Ratio(0.1), ChunkRatio: Ratio(1)},
+			{Name: "early", Ratio: Ratio(0.3), ChunkRatio: Ratio(1), TimeoutTolerance: Ratio(0.5)},
+			{Name: "rest", ChunkRatio: Ratio(0.5), FailureTolerance: Ratio(0.2)},
+		}
+		defer func() { config.ExplicitPhases = nil }()
+
+		Convey("on 10 nodes", func() {
+			depl := NewDeployment([]string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}, config.options())
+
+			Convey("should resolve each phase against the services", func() {
+				So(len(depl.phases), ShouldEqual, 3)
+				So(depl.phases[0].services, ShouldResemble, []string{"a"})
+				So(depl.phases[1].services, ShouldResemble, []string{"b", "c", "d"})
+				So(depl.phases[2].services, ShouldResemble, []string{"e", "f", "g", "h", "i", "j"})
+				So(depl.phases[1].concurrency, ShouldEqual, 3)
+				So(depl.phases[2].concurrency, ShouldEqual, 3)
+				So(depl.phases[1].timeoutsPermitted, ShouldEqual, 2)
+				So(depl.phases[2].timeoutsPermitted, ShouldEqual, 2)
+				So(depl.phases[2].failuresPermitted, ShouldEqual, 2)
+			})
+
+			Convey("should resolve counts and bounds", func() {
+				config.ExplicitPhases = []Phase{
+					{Name: "canary", Ratio: Count(2), ChunkRatio: Ratio(1)},
+					{Name: "rest", ChunkRatio: Quantity{Ratio: 0.1, Min: 2, Max: 3}, TimeoutTolerance: Quantity{Ratio: 0.5, Max: 1}},
+				}
+				depl := NewDeployment([]string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}, config.options())
+				So(depl.phases[0].services, ShouldResemble, []string{"a", "b"})
+				So(depl.phases[1].concurrency, ShouldEqual, 2)
+				So(depl.phases[1].timeoutsPermitted, ShouldEqual, 1)
+			})
+
+			Convey("should restart one node, then three, then three at a time", func() {
+				depl.opts.restartSvr = restartWithTiming
+				ch := make(chan error)
+				go func() {
+					ch <- depl.Run(context.Background())
+				}()
+				time.Sleep(quantum) // put us out of phase with the sleeps in the restart code
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 1)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 3)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 3)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 3)
+				time.Sleep(2 * quantum)
+				So(atomic.LoadInt32(&concurrency), ShouldEqual, 0)
+
+				So(<-ch, ShouldBeNil)
+			})
+
+			Convey("should tolerate failures in the phase that permits them", func() {
+				depl.opts.restartSvr = func(svr *SvRestarter) error {
+					if svr.Service == "j" {
+						return alwaysFail(svr)
+					}
+					return nil
+				}
+				So(depl.Run(context.Background()), ShouldBeNil)
+			})
+
+			Convey("should abort on failures in a phase that doesn't permit them", func() {
+				depl.opts.restartSvr = func(svr *SvRestarter) error {
+					if svr.Service != "a" && svr.Service <= "d" {
+						return alwaysFail(svr)
+					}
+					return nil
+				}
+				So(depl.Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+			})
+		})
+	})
+
+	Convey("Aborting a deployment", t, func() {
+		config.ExplicitPhases = nil
+		config.CanaryRatio = Ratio(0)
+		config.ChunkRatio = Ratio(0.25)
+		config.TimeoutTolerance = Ratio(0)
+		var started int32
+		config.restartSvr = func(svr *SvRestarter) error {
+			atomic.AddInt32(&started, 1)
+			time.Sleep(2 * quantum)
+			svr.tally.record(nil)
+			return nil
+		}
+		depl := NewDeployment([]string{"a", "b", "c", "d", "e", "f", "g", "h"}, config.options())
+
+		Convey("should let in-flight restarts finish, start no more, and return ErrAborted", func() {
+			ch := make(chan error)
+			go func() {
+				ch <- depl.Run(context.Background())
+			}()
+			time.Sleep(quantum) // two restarts in flight
+			depl.Abort()
+			depl.Abort() // idempotent
+			t1 := time.Now()
+			err := <-ch
+			So(time.Since(t1), ShouldBeGreaterThan, quantum/2)
+			So(err, ShouldEqual, ErrAborted)
+			So(atomic.LoadInt32(&started), ShouldEqual, 2)
+			So(depl.tally.snapshot().Successes, ShouldEqual, 2)
+		})
+
+		Convey("should not start at all if aborted beforehand", func() {
+			depl.Abort()
+			So(depl.Run(context.Background()), ShouldEqual, ErrAborted)
+			So(atomic.LoadInt32(&started), ShouldEqual, 0)
+		})
+
+		Convey("should stop the same way when its context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(quantum, cancel)
+			So(depl.Run(ctx), ShouldEqual, ErrAborted)
+			So(atomic.LoadInt32(&started), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Finishing a deployment with nothing left to restart in its last phase", t, func() {
+		config.ExplicitPhases = []Phase{
+			{Name: "canary", Ratio: Ratio(0.6), ChunkRatio: Ratio(1), TimeoutTolerance: Ratio(0.5)},
+			{Name: "rest", ChunkRatio: Ratio(1)},
+		}
+		defer func() { config.ExplicitPhases = nil }()
+		// b fails after the canary phase has moved on, having seen a succeed.
+		config.restartSvr = func(svr *SvRestarter) error {
+			if svr.Service == "b" {
+				time.Sleep(2 * quantum)
+				return alwaysFail(svr)
+			}
+			return nil
+		}
+
+		Convey("should still wait for, and count, the earlier phases' restarts", func() {
+			depl := NewDeployment([]string{"a", "b"}, config.options())
+			So(depl.phases[1].services, ShouldBeEmpty)
+			So(depl.Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+		})
+
+		Convey("should do the same when every service in it is skipped", func() {
+			depl := NewDeployment([]string{"a", "b", "c"}, config.options())
+			So(depl.phases[1].services, ShouldResemble, []string{"c"})
+			depl.Resume(map[string]bool{"c": true})
+			So(depl.Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+		})
+	})
+
+	Convey("Resuming a deployment", t, func() {
+		config.ExplicitPhases = nil
+		config.CanaryRatio = Ratio(0.25)
+		config.ChunkRatio = Ratio(0.0001)
+		config.TimeoutTolerance = Ratio(0)
+		var restarted []string
+		config.restartSvr = func(svr *SvRestarter) error {
+			restarted = append(restarted, svr.Service)
+			return nil
+		}
+		depl := NewDeployment([]string{"a", "b", "c", "d"}, config.options())
+
+		Convey("should skip services that were already restarted successfully", func() {
+			depl.Resume(map[string]bool{"a": true, "b": true, "c": false})
+			So(depl.Run(context.Background()), ShouldBeNil)
+			So(restarted, ShouldResemble, []string{"c", "d"})
+			So(depl.tally.snapshot().Successes, ShouldEqual, 2)
+		})
+
+		Convey("should keep the original indices", func() {
+			depl.Resume(map[string]bool{"a": true})
+			So(depl.Run(context.Background()), ShouldBeNil)
+			So(depl.svrs[0].index, ShouldEqual, 2)
+		})
+
+		Convey("should still enforce the tolerances", func() {
+			depl.opts.restartSvr = alwaysFail
+			depl.Resume(map[string]bool{"a": true})
+			So(depl.Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+		})
+	})
+
+	Convey("Choosing canaries", t, func() {
+		Convey("should not choose any if ratio = 0", func() {
+			c, nc := chooseCanaries([]string{"a", "b", "c", "d"}, Ratio(0))
+			So(len(c), ShouldEqual, 0)
+			So(len(nc), ShouldEqual, 4)
+		})
+		Convey("should choose one if ratio is small", func() {
+			c, nc := chooseCanaries([]string{"a", "b", "c", "d", "e", "f", "g"}, Ratio(0.001))
+			So(len(c), ShouldEqual, 1)
+			So(len(nc), ShouldEqual, 6)
+		})
+		Convey("Should round up in general", func() {
+			c, nc := chooseCanaries([]string{"a", "b", "c", "d", "e"}, Ratio(0.5))
+			So(len(c), ShouldEqual, 3)
+			So(len(nc), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Pinning canaries", t, func() {
+		opts := Options{Phases: CanaryPhases(Phase{Ratio: Count(1)}, Phase{ChunkRatio: Ratio(1)})}
+
+		Convey("should restart them first, in the order given", func() {
+			opts.Canaries = []string{"c"}
+			plan := NewDeployment([]string{"a", "b", "c", "d"}, opts).Plan()
+			So(plan.Canaries, ShouldResemble, []string{"c"})
+			So(plan.Phases[1].Services, ShouldResemble, []string{"a", "b", "d"})
+		})
+		Convey("should enlarge the first phase to hold them all", func() {
+			opts.Canaries = []string{"d", "x", "b"}
+			plan := NewDeployment([]string{"a", "b", "c", "d"}, opts).Plan()
+			So(plan.Canaries, ShouldResemble, []string{"d", "b"})
+			So(plan.Phases[1].Services, ShouldResemble, []string{"a", "c"})
+		})
+		Convey("should put them ahead of services with higher priorities", func() {
+			opts.Canaries = []string{"d"}
+			opts.Overrides = map[string]Overrides{"a": {Priority: 1}}
+			plan := NewDeployment([]string{"a", "b", "c", "d"}, opts).Plan()
+			So(plan.Canaries, ShouldResemble, []string{"d"})
+			So(plan.Phases[1].Services, ShouldResemble, []string{"a", "b", "c"})
+		})
+	})
+
+	Convey("Deciding on permitted timeouts", t, func() {
+		Convey("should not allow any if ratio = 0", func() {
+			So(permittedTimeouts([]string{"a", "b", "c"}, Ratio(0)), ShouldEqual, 0)
+		})
+		Convey("should allow one if ratio is small", func() {
+			So(permittedTimeouts([]string{"a", "b", "c", "d", "e", "f"}, Ratio(0.001)), ShouldEqual, 1)
+		})
+		Convey("should allow all if ratio is 1", func() {
+			So(permittedTimeouts([]string{"a", "b", "c", "d", "e", "f"}, Ratio(1)), ShouldEqual, 6)
+		})
+	})
+}
@@ -1,4 +1,4 @@
-package main
+package rollout
 
 import (
 	"errors"
@@ -13,6 +13,18 @@ var ErrTooManyTimeouts = errors.New("too many services timed out while restartin
 // should be aborted.
 var ErrTooManyFailures = errors.New("too many services failed to restart")
 
+// ErrAborted means that the deploy was interrupted (e.g. by SIGINT) before it
+// finished.
+var ErrAborted = errors.New("rollout aborted")
+
+// ErrNotApproved means that the Deployment's Approver declined to let the
+// deploy continue past a phase.
+var ErrNotApproved = errors.New("rollout not approved to continue")
+
+// ErrRollbackFailed means that, after a failed deploy, some of the services
+// could not be rolled back and restarted.
+var ErrRollbackFailed = errors.New("some services could not be rolled back")
+
 // ErrRestartPreempted happens when we terminate the deploy early due to a
 // sufficient number of services restarting successfully to consider the deploy
 // a success even if every remaining service times out.
@@ -43,3 +55,26 @@ type ErrRestartFailed struct {
 func (e ErrRestartFailed) Error() string {
 	return fmt.Sprintf("restart failed for service '%s': %s", e.Service, e.Message)
 }
+
+// ErrRestartUnstable indicates that a service restarted, but then went down or
+// was restarted again by runit before it had been up for long enough to be
+// considered stable.
+type ErrRestartUnstable struct {
+	Service string
+	Message string
+}
+
+func (e ErrRestartUnstable) Error() string {
+	return fmt.Sprintf("service '%s' did not stay up after restarting: %s", e.Service, e.Message)
+}
+
+// ErrBakeFailed indicates that a service restarted in a phase went down or
+// failed its health check while the phase was baking.
+type ErrBakeFailed struct {
+	Service string
+	Message string
+}
+
+func (e ErrBakeFailed) Error() string {
+	return fmt.Sprintf("service '%s' failed while baking: %s", e.Service, e.Message)
+}
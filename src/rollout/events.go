@@ -0,0 +1,104 @@
+package rollout
+
+import (
+	"sync"
+	"time"
+)
+
+// The kinds of Event reported over the course of a rollout.
+const (
+	EventRolloutStarted  = "rollout_started"
+	EventPhaseStarted    = "phase_started"
+	EventRestarting      = "restarting"
+	EventSucceeded       = "succeeded"
+	EventTimedOut        = "timed_out"
+	EventFailed          = "failed"
+	EventUnstable        = "unstable"
+	EventPreempted       = "preempted"
+	EventRolloutFinished = "rollout_finished"
+
+	EventBakeStarted      = "bake_started"
+	EventBakeFailed       = "bake_failed"
+	EventAwaitingApproval = "awaiting_approval"
+	EventApproved         = "approved"
+
+	EventRollbackStarted  = "rollback_started"
+	EventRollbackFinished = "rollback_finished"
+)
+
+// Event describes a change in the state of a rollout. Service events are
+// reported by SvRestarter; the rest by Deployment. Both pass them to
+// Options.OnEvent.
+type Event struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	Phase string    `json:"phase,omitempty"`
+
+	Service string `json:"service,omitempty"`
+	Index   int    `json:"index,omitempty"`
+	// Services is the total number of services in the rollout.
+	Services int `json:"services"`
+	// PhaseServices is the number of services in the phase, for phase events.
+	PhaseServices int `json:"phase_services,omitempty"`
+
+	// Duration, in seconds, of the restart or the rollout.
+	Duration float64 `json:"duration,omitempty"`
+	Counts   *Counts `json:"counts,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Counts tallies the outcomes of the restarts finished so far.
+type Counts struct {
+	Successes int `json:"successes"`
+	Timeouts  int `json:"timeouts"`
+	Failures  int `json:"failures"`
+	Unstable  int `json:"unstable"`
+	Preempted int `json:"preempted"`
+}
+
+// tally keeps Counts up to date as restarts finish, from any goroutine.
+type tally struct {
+	sync.Mutex
+	counts Counts
+}
+
+func (t *tally) record(result error) Counts {
+	t.Lock()
+	defer t.Unlock()
+	switch result.(type) {
+	case nil:
+		t.counts.Successes++
+	case ErrRestartTimeout:
+		t.counts.Timeouts++
+	case ErrRestartFailed:
+		t.counts.Failures++
+	case ErrRestartUnstable:
+		t.counts.Unstable++
+	case ErrRestartPreempted:
+		t.counts.Preempted++
+	}
+	return t.counts
+}
+
+func (t *tally) snapshot() Counts {
+	t.Lock()
+	defer t.Unlock()
+	return t.counts
+}
+
+// resultEvent returns the kind of Event reporting a restart's result.
+func resultEvent(result error) string {
+	switch result.(type) {
+	case nil:
+		return EventSucceeded
+	case ErrRestartTimeout:
+		return EventTimedOut
+	case ErrRestartFailed:
+		return EventFailed
+	case ErrRestartUnstable:
+		return EventUnstable
+	case ErrRestartPreempted:
+		return EventPreempted
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package rollout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEvents(t *testing.T) {
+
+	Convey("Tallying results", t, func() {
+		var tl tally
+		tl.record(nil)
+		tl.record(nil)
+		tl.record(ErrRestartTimeout{})
+		tl.record(ErrRestartFailed{})
+		tl.record(ErrRestartUnstable{})
+		counts := tl.record(ErrRestartPreempted{})
+		So(counts, ShouldResemble, Counts{Successes: 2, Timeouts: 1, Failures: 1, Unstable: 1, Preempted: 1})
+		So(tl.snapshot(), ShouldResemble, counts)
+	})
+
+	Convey("Running a deployment", t, func() {
+		var recorder eventRecorder
+		opts := Options{
+			Phases: []Phase{
+				{Name: "canary", Ratio: Ratio(0.3), ChunkRatio: Ratio(1)},
+				{Name: "rest", ChunkRatio: Ratio(0.001), FailureTolerance: Ratio(1)},
+			},
+			Restarter: RestarterFunc(func(s string, t time.Duration, a chan<- struct{}) error {
+				close(a)
+				if s == "c" {
+					return ErrRestartFailed{Service: s, Message: "boom"}
+				}
+				return nil
+			}),
+			OnEvent: recorder.record,
+		}
+		err := NewDeployment([]string{"a", "b", "c"}, opts).Run(context.Background())
+		events := recorder.events
+
+		Convey("should report every change of state", func() {
+			So(err, ShouldBeNil)
+			var kinds []string
+			for _, e := range events {
+				kinds = append(kinds, e.Event+" "+e.Phase+" "+e.Service)
+			}
+			So(kinds, ShouldResemble, []string{
+				"rollout_started  ",
+				"phase_started canary ",
+				"restarting canary a",
+				"succeeded canary a",
+				"phase_started rest ",
+				"restarting rest b",
+				"succeeded rest b",
+				"restarting rest c",
+				"failed rest c",
+				"rollout_finished  ",
+			})
+			So(*events[8].Counts, ShouldResemble, Counts{Successes: 2, Failures: 1})
+			So(events[8].Error, ShouldEqual, "restart failed for service 'c': boom")
+			So(*events[9].Counts, ShouldResemble, Counts{Successes: 2, Failures: 1})
+		})
+	})
+}
@@ -0,0 +1,140 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHealthCheckInterval is how long to wait between failed probes.
+const DefaultHealthCheckInterval = time.Second
+
+// Probe checks once whether a service is healthy, giving up after timeout.
+type Probe interface {
+	Check(service string, timeout time.Duration) error
+}
+
+// HealthCheck repeatedly probes a freshly-restarted service until it passes or
+// Timeout elapses. Interval defaults to DefaultHealthCheckInterval.
+type HealthCheck struct {
+	Probe    Probe
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+// errProbeTimeout indicates that a probe got no answer in time, as opposed to
+// getting an unhealthy answer.
+type errProbeTimeout struct {
+	error
+}
+
+// Wait blocks until the service passes its probe, returning nil. If the
+// service is still unhealthy once the timeout elapses, it returns
+// ErrRestartTimeout if the last probe got no answer in time, or
+// ErrRestartFailed otherwise.
+func (h *HealthCheck) Wait(service string) error {
+	interval := h.Interval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	deadline := time.Now().Add(h.Timeout)
+	for {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			remaining = time.Millisecond
+		}
+		err := h.Probe.Check(service, remaining)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			if _, ok := err.(errProbeTimeout); ok {
+				return ErrRestartTimeout{Service: service}
+			}
+			return ErrRestartFailed{Service: service, Message: "health check failed: " + err.Error()}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// ParseProbe builds a Probe from its specification, as given to
+// `-health-check`. "{service}" in the specification is replaced with the name
+// of the service being probed.
+//
+//	http://127.0.0.1:8080/status  HTTP GET, expecting httpStatus
+//	tcp://127.0.0.1:8080          TCP connect
+//	exec:/usr/local/bin/check     command run via `sh -c`, expecting exit 0
+func ParseProbe(spec string, httpStatus int) (Probe, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return httpProbe{URL: spec, Status: httpStatus}, nil
+	case strings.HasPrefix(spec, "tcp://"):
+		return tcpProbe{Address: strings.TrimPrefix(spec, "tcp://")}, nil
+	case strings.HasPrefix(spec, "exec:"):
+		return commandProbe{Command: strings.TrimPrefix(spec, "exec:")}, nil
+	}
+	return nil, fmt.Errorf("unrecognized health check %q: expected http://, https://, tcp:// or exec:", spec)
+}
+
+// ExpandService replaces "{service}" in s with the service's name.
+func ExpandService(s, service string) string {
+	return strings.Replace(s, "{service}", service, -1)
+}
+
+type httpProbe struct {
+	URL    string
+	Status int
+}
+
+func (p httpProbe) Check(service string, timeout time.Duration) error {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(ExpandService(p.URL, service))
+	if err != nil {
+		if isTimeout(err) {
+			return errProbeTimeout{err}
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != p.Status {
+		return fmt.Errorf("GET %s: expected status %d, got %d", resp.Request.URL, p.Status, resp.StatusCode)
+	}
+	return nil
+}
+
+type tcpProbe struct {
+	Address string
+}
+
+func (p tcpProbe) Check(service string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", ExpandService(p.Address, service), timeout)
+	if err != nil {
+		if isTimeout(err) {
+			return errProbeTimeout{err}
+		}
+		return err
+	}
+	return conn.Close()
+}
+
+type commandProbe struct {
+	Command string
+}
+
+func (p commandProbe) Check(service string, timeout time.Duration) error {
+	err := RunCommand(context.Background(), ExpandService(p.Command, service), []string{"SV_ROLLOUT_SERVICE=" + service}, timeout)
+	if _, ok := err.(errCommandTimeout); ok {
+		return errProbeTimeout{err}
+	}
+	return err
+}
+
+func isTimeout(err error) bool {
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return true
+	}
+	return false
+}
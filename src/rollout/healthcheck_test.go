@@ -0,0 +1,105 @@
+package rollout
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type stubProbe struct {
+	results []error
+	calls   int
+}
+
+func (p *stubProbe) Check(service string, timeout time.Duration) error {
+	err := p.results[p.calls]
+	if p.calls < len(p.results)-1 {
+		p.calls++
+	}
+	return err
+}
+
+func TestHealthCheck(t *testing.T) {
+
+	Convey("Waiting for a health check", t, func() {
+		h := &HealthCheck{Timeout: 50 * time.Millisecond, Interval: 10 * time.Millisecond}
+
+		Convey("should succeed once the probe passes", func() {
+			h.Probe = &stubProbe{results: []error{errors.New("not yet"), nil}}
+			So(h.Wait("svc"), ShouldBeNil)
+		})
+		Convey("should fail if the probe keeps failing", func() {
+			h.Probe = &stubProbe{results: []error{errors.New("unhealthy")}}
+			err := h.Wait("svc")
+			So(err, ShouldResemble, ErrRestartFailed{Service: "svc", Message: "health check failed: unhealthy"})
+		})
+		Convey("should time out if the probe keeps timing out", func() {
+			h.Probe = &stubProbe{results: []error{errProbeTimeout{errors.New("slow")}}}
+			err := h.Wait("svc")
+			So(err, ShouldResemble, ErrRestartTimeout{Service: "svc"})
+		})
+	})
+
+	Convey("Parsing a probe", t, func() {
+		Convey("should recognize each kind", func() {
+			p, err := ParseProbe("http://127.0.0.1/{service}", 204)
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, httpProbe{URL: "http://127.0.0.1/{service}", Status: 204})
+			p, err = ParseProbe("tcp://127.0.0.1:80", 200)
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, tcpProbe{Address: "127.0.0.1:80"})
+			p, err = ParseProbe("exec:true", 200)
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, commandProbe{Command: "true"})
+		})
+		Convey("should reject anything else", func() {
+			_, err := ParseProbe("udp://127.0.0.1:80", 200)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("An HTTP probe", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthy-svc" {
+				w.WriteHeader(200)
+			} else {
+				w.WriteHeader(503)
+			}
+		}))
+		defer server.Close()
+		p := httpProbe{URL: server.URL + "/{service}", Status: 200}
+
+		So(p.Check("healthy-svc", time.Second), ShouldBeNil)
+		So(p.Check("sick-svc", time.Second), ShouldNotBeNil)
+	})
+
+	Convey("A TCP probe", t, func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := l.Addr().String()
+
+		So(tcpProbe{Address: addr}.Check("svc", time.Second), ShouldBeNil)
+		l.Close()
+		So(tcpProbe{Address: addr}.Check("svc", time.Second), ShouldNotBeNil)
+	})
+
+	Convey("A command probe", t, func() {
+		Convey("should pass when the command succeeds", func() {
+			So(commandProbe{Command: `test "$SV_ROLLOUT_SERVICE" = svc`}.Check("svc", time.Second), ShouldBeNil)
+		})
+		Convey("should fail when the command fails", func() {
+			err := commandProbe{Command: "echo {service} is sick; false"}.Check("svc", time.Second)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "svc is sick")
+		})
+		Convey("should time out when the command hangs", func() {
+			err := commandProbe{Command: "sleep 5"}.Check("svc", 50*time.Millisecond)
+			So(err, ShouldHaveSameTypeAs, errProbeTimeout{})
+		})
+	})
+}
@@ -0,0 +1,110 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The hooks run around each restart.
+const (
+	HookPreRestart  = "pre-restart"
+	HookPostRestart = "post-restart"
+)
+
+// HookInfo describes the restart a Hook is run for.
+type HookInfo struct {
+	// Hook is HookPreRestart or HookPostRestart.
+	Hook     string
+	Service  string
+	Svdir    string
+	Index    int
+	Services int
+	Phase    string
+	// Result is the kind of Event reporting the restart's result (e.g.
+	// EventSucceeded), and Error its error, for post-restart hooks.
+	Result string
+	Error  string
+}
+
+// Env describes the restart in SV_ROLLOUT_* environment variables.
+func (h HookInfo) Env() []string {
+	env := []string{
+		"SV_ROLLOUT_HOOK=" + h.Hook,
+		"SV_ROLLOUT_SERVICE=" + h.Service,
+		"SV_ROLLOUT_SVDIR=" + h.Svdir,
+		"SV_ROLLOUT_INDEX=" + strconv.Itoa(h.Index),
+		"SV_ROLLOUT_SERVICES=" + strconv.Itoa(h.Services),
+		"SV_ROLLOUT_PHASE=" + h.Phase,
+	}
+	if h.Hook == HookPostRestart {
+		env = append(env, "SV_ROLLOUT_RESULT="+h.Result, "SV_ROLLOUT_ERROR="+h.Error)
+	}
+	return env
+}
+
+// Hook is run before or after each restart, e.g. to drain the service from a
+// load balancer and add it back again. The post-restart hook is run whatever
+// the result, though after a preempted restart it's run without waiting for
+// the restart to finish.
+type Hook interface {
+	Run(info HookInfo) error
+}
+
+// HookFunc adapts an ordinary function to the Hook interface.
+type HookFunc func(info HookInfo) error
+
+// Run calls f.
+func (f HookFunc) Run(info HookInfo) error {
+	return f(info)
+}
+
+// CommandHook runs a command via `sh -c` as a Hook, with "{service}" replaced
+// by the service's name and the restart described in its environment (see
+// HookInfo.Env). It's killed if it runs for longer than Timeout, unless
+// Timeout is zero.
+type CommandHook struct {
+	Command string
+	Timeout time.Duration
+}
+
+// Run runs the command, returning an error if it fails or times out.
+func (h CommandHook) Run(info HookInfo) error {
+	return RunCommand(context.Background(), ExpandService(h.Command, info.Service), info.Env(), h.Timeout)
+}
+
+// runHook runs hook for the restart, if there is one, with result being the
+// restart's result for a post-restart hook. A failed hook fails the restart,
+// unless hook failures are ignored.
+func (s *SvRestarter) runHook(hook Hook, name string, result error) error {
+	if hook == nil {
+		return nil
+	}
+	info := HookInfo{
+		Hook:     name,
+		Service:  s.Service,
+		Svdir:    s.svdir,
+		Index:    s.index,
+		Services: s.nServices,
+		Phase:    s.phase,
+	}
+	if name == HookPostRestart {
+		info.Result = resultEvent(result)
+		if result != nil {
+			info.Error = result.Error()
+		}
+	}
+	err := hook.Run(info)
+	if err == nil {
+		return nil
+	}
+	message := fmt.Sprintf("%s hook failed: %s", name, err)
+	if s.ignoreHookFailures {
+		if s.logf != nil {
+			s.logf("[%d/%d] (%s) %s, ignoring", s.index, s.nServices, s.Service, message)
+		}
+		return nil
+	}
+	return ErrRestartFailed{Service: s.Service, Message: message}
+}
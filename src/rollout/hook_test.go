@@ -0,0 +1,150 @@
+package rollout
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHookInfo(t *testing.T) {
+
+	Convey("A hook's environment", t, func() {
+		info := HookInfo{
+			Hook:     HookPreRestart,
+			Service:  "svc",
+			Svdir:    "/etc/service",
+			Index:    2,
+			Services: 3,
+			Phase:    "canary",
+		}
+
+		Convey("should describe the restart", func() {
+			So(info.Env(), ShouldResemble, []string{
+				"SV_ROLLOUT_HOOK=pre-restart",
+				"SV_ROLLOUT_SERVICE=svc",
+				"SV_ROLLOUT_SVDIR=/etc/service",
+				"SV_ROLLOUT_INDEX=2",
+				"SV_ROLLOUT_SERVICES=3",
+				"SV_ROLLOUT_PHASE=canary",
+			})
+		})
+		Convey("should include the result after the restart", func() {
+			info.Hook = HookPostRestart
+			info.Result = EventFailed
+			info.Error = "boom"
+			env := info.Env()
+			So(env[0], ShouldEqual, "SV_ROLLOUT_HOOK=post-restart")
+			So(env[len(env)-2:], ShouldResemble, []string{"SV_ROLLOUT_RESULT=failed", "SV_ROLLOUT_ERROR=boom"})
+		})
+	})
+}
+
+func TestCommandHook(t *testing.T) {
+
+	Convey("A command hook", t, func() {
+		dir, _ := ioutil.TempDir("", "sv-rollout-hook")
+		defer os.RemoveAll(dir)
+		info := HookInfo{Hook: HookPostRestart, Service: "svc", Index: 1, Services: 2, Result: EventSucceeded}
+
+		Convey("should be run with the service expanded and the restart in its environment", func() {
+			out := filepath.Join(dir, "out")
+			h := CommandHook{Command: `echo {service} $SV_ROLLOUT_INDEX $SV_ROLLOUT_RESULT > ` + out}
+			So(h.Run(info), ShouldBeNil)
+			b, _ := ioutil.ReadFile(out)
+			So(strings.TrimSpace(string(b)), ShouldEqual, "svc 1 succeeded")
+		})
+		Convey("should fail with its output if the command fails", func() {
+			err := CommandHook{Command: "echo draining failed; false"}.Run(info)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "draining failed")
+		})
+		Convey("should be killed if it times out", func() {
+			start := time.Now()
+			err := CommandHook{Command: "sleep 5", Timeout: 50 * time.Millisecond}.Run(info)
+			So(err, ShouldHaveSameTypeAs, errCommandTimeout{})
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+		Convey("should be killed by KillCommands", func() {
+			start := time.Now()
+			time.AfterFunc(50*time.Millisecond, KillCommands)
+			err := CommandHook{Command: "sleep 5"}.Run(info)
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+}
+
+func TestSvRestarterHooks(t *testing.T) {
+
+	Convey("Restarting a service with hooks", t, func() {
+		var (
+			events    eventRecorder
+			restarted bool
+			ran       []HookInfo
+		)
+		svr := NewSvRestarter("svc", 1, 1, time.Second)
+		svr.report = events.record
+		svr.logf = func(string, ...interface{}) {}
+		svr.restarter = RestarterFunc(func(s string, t time.Duration, started chan<- struct{}) error {
+			restarted = true
+			close(started)
+			return nil
+		})
+		hook := func(err error) Hook {
+			return HookFunc(func(info HookInfo) error {
+				ran = append(ran, info)
+				return err
+			})
+		}
+
+		Convey("should run the hooks either side of the restart", func() {
+			svr.preRestart = hook(nil)
+			svr.postRestart = hook(nil)
+			So(svr.Restart(), ShouldBeNil)
+			So(restarted, ShouldBeTrue)
+			So(len(ran), ShouldEqual, 2)
+			So(ran[0].Hook, ShouldEqual, HookPreRestart)
+			So(ran[1].Hook, ShouldEqual, HookPostRestart)
+			So(ran[1].Result, ShouldEqual, EventSucceeded)
+		})
+		Convey("should pass the restart's failure to the post-restart hook", func() {
+			svr.restarter = RestarterFunc(func(s string, t time.Duration, started chan<- struct{}) error {
+				close(started)
+				return ErrRestartTimeout{Service: s}
+			})
+			svr.postRestart = hook(nil)
+			So(svr.Restart(), ShouldHaveSameTypeAs, ErrRestartTimeout{})
+			So(ran[0].Result, ShouldEqual, EventTimedOut)
+			So(ran[0].Error, ShouldNotBeEmpty)
+		})
+		Convey("should fail without restarting if the pre-restart hook fails", func() {
+			svr.preRestart = hook(errors.New("still serving"))
+			svr.postRestart = hook(nil)
+			err := svr.Restart()
+			So(err, ShouldResemble, ErrRestartFailed{Service: "svc", Message: "pre-restart hook failed: still serving"})
+			So(restarted, ShouldBeFalse)
+			So(ran[1].Result, ShouldEqual, EventFailed)
+			So(events.services(), ShouldResemble, []string{"svc restarting", "svc failed"})
+		})
+		Convey("should fail if the post-restart hook fails", func() {
+			svr.postRestart = hook(errors.New("not registered"))
+			err := svr.Restart()
+			So(err, ShouldResemble, ErrRestartFailed{Service: "svc", Message: "post-restart hook failed: not registered"})
+			So(restarted, ShouldBeTrue)
+		})
+		Convey("should carry on if hook failures are ignored", func() {
+			svr.ignoreHookFailures = true
+			svr.preRestart = hook(errors.New("still serving"))
+			svr.postRestart = hook(errors.New("not registered"))
+			So(svr.Restart(), ShouldBeNil)
+			So(restarted, ShouldBeTrue)
+			So(events.services(), ShouldResemble, []string{"svc restarting", "svc succeeded"})
+		})
+	})
+}
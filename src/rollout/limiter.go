@@ -0,0 +1,89 @@
+package rollout
+
+import (
+	"sync"
+	"time"
+)
+
+// startLimiter paces the starts of restarts, for services that hammer shared
+// resources as they boot. It's a token bucket, refilled at perMinute tokens a
+// minute and holding at most burst of them, combined with a minimum interval
+// between consecutive starts. A zero value doesn't limit anything.
+type startLimiter struct {
+	sync.Mutex
+	perMinute   float64
+	burst       int
+	minInterval time.Duration
+
+	tokens    float64
+	updated   time.Time
+	lastStart time.Time
+
+	// debugf, if set, logs each wait.
+	debugf func(format string, v ...interface{})
+}
+
+func newStartLimiter(perMinute float64, burst int, minInterval time.Duration) *startLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &startLimiter{
+		perMinute:   perMinute,
+		burst:       burst,
+		minInterval: minInterval,
+		tokens:      float64(burst),
+	}
+}
+
+// reserve returns the time at which the next restart may start, and counts it
+// as started then. Later reservations queue up behind it.
+func (l *startLimiter) reserve(now time.Time) time.Time {
+	l.Lock()
+	defer l.Unlock()
+
+	at := now
+	if l.perMinute > 0 {
+		if !l.updated.IsZero() {
+			l.tokens += now.Sub(l.updated).Minutes() * l.perMinute
+			if l.tokens > float64(l.burst) {
+				l.tokens = float64(l.burst)
+			}
+		}
+		l.updated = now
+		// tokens go negative as restarts queue up for the bucket to refill.
+		l.tokens--
+		if l.tokens < 0 {
+			at = now.Add(time.Duration(-l.tokens / l.perMinute * float64(time.Minute)))
+		}
+	}
+	if l.minInterval > 0 && !l.lastStart.IsZero() {
+		if earliest := l.lastStart.Add(l.minInterval); at.Before(earliest) {
+			at = earliest
+		}
+	}
+	l.lastStart = at
+	return at
+}
+
+// wait blocks until the next restart may start. It returns false if abort is
+// closed first.
+func (l *startLimiter) wait(abort <-chan struct{}) bool {
+	if l == nil || (l.perMinute <= 0 && l.minInterval <= 0) {
+		return true
+	}
+	delay := l.reserve(time.Now()).Sub(time.Now())
+	if delay <= 0 {
+		return true
+	}
+	if l.debugf != nil {
+		l.debugf("waiting %s before starting the next restart", delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-abort:
+		return false
+	}
+}
@@ -0,0 +1,83 @@
+package rollout
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStartLimiter(t *testing.T) {
+	t0 := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	Convey("A start limiter", t, func() {
+		Convey("with a rate should space starts out once the burst is used up", func() {
+			l := newStartLimiter(30, 2, 0)
+			So(l.reserve(t0), ShouldResemble, t0)
+			So(l.reserve(t0), ShouldResemble, t0)
+			So(l.reserve(t0), ShouldResemble, t0.Add(2*time.Second))
+			So(l.reserve(t0), ShouldResemble, t0.Add(4*time.Second))
+		})
+
+		Convey("with a rate should refill the bucket over time, up to the burst", func() {
+			l := newStartLimiter(60, 2, 0)
+			l.reserve(t0)
+			l.reserve(t0)
+			So(l.reserve(t0.Add(time.Minute)), ShouldResemble, t0.Add(time.Minute))
+			So(l.reserve(t0.Add(time.Minute)), ShouldResemble, t0.Add(time.Minute))
+			So(l.reserve(t0.Add(time.Minute)), ShouldResemble, t0.Add(time.Minute+time.Second))
+		})
+
+		Convey("with a minimum interval should keep starts apart", func() {
+			l := newStartLimiter(0, 1, 5*time.Second)
+			So(l.reserve(t0), ShouldResemble, t0)
+			So(l.reserve(t0.Add(time.Second)), ShouldResemble, t0.Add(5*time.Second))
+			So(l.reserve(t0.Add(20*time.Second)), ShouldResemble, t0.Add(20*time.Second))
+		})
+
+		Convey("with neither should never wait", func() {
+			l := newStartLimiter(0, 1, 0)
+			So(l.wait(nil), ShouldBeTrue)
+			var nilLimiter *startLimiter
+			So(nilLimiter.wait(nil), ShouldBeTrue)
+		})
+
+		Convey("should stop waiting when aborted", func() {
+			l := newStartLimiter(0, 1, time.Hour)
+			l.reserve(time.Now())
+			abort := make(chan struct{})
+			close(abort)
+			So(l.wait(abort), ShouldBeFalse)
+		})
+	})
+
+	Convey("A deployment with a minimum start interval", t, func() {
+		c := config{
+			CanaryRatio:      Ratio(0),
+			ChunkRatio:       Ratio(1),
+			Timeout:          1,
+			MinStartInterval: quantum,
+		}
+		var started int32
+		c.restartSvr = func(svr *SvRestarter) error {
+			atomic.AddInt32(&started, 1)
+			return nil
+		}
+		depl := NewDeployment([]string{"a", "b", "c", "d"}, c.options())
+
+		Convey("should start restarts no closer together, however many may run at once", func() {
+			ch := make(chan error)
+			t1 := time.Now()
+			go func() {
+				ch <- depl.Run(context.Background())
+			}()
+			time.Sleep(quantum / 2)
+			So(atomic.LoadInt32(&started), ShouldEqual, 1)
+			So(<-ch, ShouldBeNil)
+			So(time.Since(t1), ShouldBeGreaterThanOrEqualTo, 3*quantum)
+			So(atomic.LoadInt32(&started), ShouldEqual, 4)
+		})
+	})
+}
@@ -0,0 +1,86 @@
+package rollout
+
+import (
+	"sort"
+	"time"
+)
+
+// Overrides replace a Deployment's settings for a single service. Anything
+// left unset falls back to the Deployment's own.
+type Overrides struct {
+	// Timeout is how long to wait for the service to restart.
+	Timeout time.Duration
+	// HealthCheck must pass before the service's restart counts as
+	// successful. NoHealthCheck skips the health check for the service.
+	HealthCheck   *HealthCheck
+	NoHealthCheck bool
+	// PreRestart and PostRestart are run before and after the service's
+	// restart.
+	PreRestart  Hook
+	PostRestart Hook
+	// Priority orders the services before they're divided into phases:
+	// services with a higher priority restart earlier, so are the first picked
+	// as canaries, and those with a negative priority restart last.
+	Priority int
+}
+
+// overrides returns the Overrides for svc, if any.
+func (d *Deployment) overrides(svc string) Overrides {
+	return d.opts.Overrides[svc]
+}
+
+// timeoutFor returns how long to wait for svc to restart.
+func (d *Deployment) timeoutFor(svc string) time.Duration {
+	if o := d.overrides(svc); o.Timeout > 0 {
+		return o.Timeout
+	}
+	return d.timeout
+}
+
+// healthCheckFor returns the health check svc must pass, if any.
+func (d *Deployment) healthCheckFor(svc string) *HealthCheck {
+	o := d.overrides(svc)
+	switch {
+	case o.NoHealthCheck:
+		return nil
+	case o.HealthCheck != nil:
+		return o.HealthCheck
+	}
+	return d.healthCheck
+}
+
+// hooksFor returns the hooks to run before and after restarting svc.
+func (d *Deployment) hooksFor(svc string) (pre, post Hook) {
+	o := d.overrides(svc)
+	pre, post = d.opts.PreRestart, d.opts.PostRestart
+	if o.PreRestart != nil {
+		pre = o.PreRestart
+	}
+	if o.PostRestart != nil {
+		post = o.PostRestart
+	}
+	return pre, post
+}
+
+// prioritize returns services ordered by their overridden Priority, highest
+// first, otherwise keeping the order they were given in.
+func prioritize(services []string, overrides map[string]Overrides) []string {
+	if len(overrides) == 0 {
+		return services
+	}
+	sorted := byPriority{append([]string(nil), services...), overrides}
+	sort.Stable(sorted)
+	return sorted.services
+}
+
+// byPriority sorts services by their overridden Priority, highest first.
+type byPriority struct {
+	services  []string
+	overrides map[string]Overrides
+}
+
+func (p byPriority) Len() int      { return len(p.services) }
+func (p byPriority) Swap(i, j int) { p.services[i], p.services[j] = p.services[j], p.services[i] }
+func (p byPriority) Less(i, j int) bool {
+	return p.overrides[p.services[i]].Priority > p.overrides[p.services[j]].Priority
+}
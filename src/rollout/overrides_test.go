@@ -0,0 +1,64 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOverrides(t *testing.T) {
+
+	Convey("Overriding settings for individual services", t, func() {
+		global := &HealthCheck{Probe: &stubProbe{results: []error{errors.New("unhealthy")}}, Timeout: time.Second}
+		own := &HealthCheck{Probe: &stubProbe{results: []error{nil}}, Timeout: time.Second}
+		pre := HookFunc(func(HookInfo) error { return nil })
+		opts := config{CanaryRatio: Count(1), ChunkRatio: Ratio(1), Timeout: 90}.options()
+		opts.HealthCheck = global
+		opts.Overrides = map[string]Overrides{
+			"b": {Timeout: 900 * time.Second, NoHealthCheck: true, Priority: -1},
+			"c": {HealthCheck: own, PreRestart: pre, Priority: 1},
+		}
+		d := NewDeployment([]string{"a", "b", "c"}, opts)
+
+		Convey("should fall back to the deployment's settings", func() {
+			So(d.timeoutFor("a"), ShouldEqual, 90*time.Second)
+			So(d.healthCheckFor("a"), ShouldEqual, global)
+			pre, post := d.hooksFor("a")
+			So(pre, ShouldBeNil)
+			So(post, ShouldBeNil)
+		})
+		Convey("should use the service's own settings", func() {
+			So(d.timeoutFor("b"), ShouldEqual, 900*time.Second)
+			So(d.healthCheckFor("b"), ShouldBeNil)
+			So(d.healthCheckFor("c"), ShouldEqual, own)
+			pre, _ := d.hooksFor("c")
+			So(pre, ShouldNotBeNil)
+		})
+		Convey("should restart services with higher priorities first", func() {
+			So(d.Plan().Canaries, ShouldResemble, []string{"c"})
+			So(d.Plan().Phases[1].Services, ShouldResemble, []string{"a", "b"})
+			So(d.Plan().Timeouts, ShouldResemble, map[string]int{"b": 900})
+		})
+		Convey("should keep the order services were given in without priorities", func() {
+			So(prioritize([]string{"b", "a"}, nil), ShouldResemble, []string{"b", "a"})
+			So(prioritize([]string{"b", "a", "c"}, map[string]Overrides{"c": {Priority: 2}}), ShouldResemble, []string{"c", "b", "a"})
+		})
+
+		Convey("should restart each service with its own timeout", func() {
+			var mu sync.Mutex
+			timeouts := make(map[string]time.Duration)
+			opts.restartSvr = func(svr *SvRestarter) error {
+				mu.Lock()
+				defer mu.Unlock()
+				timeouts[svr.Service] = svr.timeout
+				return nil
+			}
+			So(NewDeployment([]string{"a", "b", "c"}, opts).Run(context.Background()), ShouldBeNil)
+			So(timeouts, ShouldResemble, map[string]time.Duration{"a": 90 * time.Second, "b": 900 * time.Second, "c": 90 * time.Second})
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package rollout
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Phase describes one wave of a rollout. Phases are restarted in order, and a
+// phase only begins once the previous phase has restarted enough services
+// successfully.
+type Phase struct {
+	Name string
+	// Ratio is how many of the total number of services to restart in this
+	// phase. The last phase always restarts every service not claimed by an
+	// earlier phase.
+	Ratio Quantity
+	// ChunkRatio is how many of this phase's services are restarted
+	// concurrently.
+	ChunkRatio Quantity
+	// TimeoutTolerance is how many of this phase's services are permitted to
+	// time out without causing the deploy to fail.
+	TimeoutTolerance Quantity
+	// FailureTolerance is how many of this phase's services are permitted to
+	// fail without causing the deploy to fail.
+	FailureTolerance Quantity
+	// FailureCount is the number of this phase's services permitted to fail,
+	// if that's more than FailureTolerance permits.
+	FailureCount int
+	// Bake is how long to wait, re-checking the phase's services, before the
+	// next phase begins. If zero, Options.Bake is used.
+	Bake time.Duration
+}
+
+// CanaryPhases returns the traditional rollout plan, given by sv-rollout's
+// canary and chunk options: a "canary" phase, restarted all at once, then a
+// "main" phase restarting every other service. The phases' names, the canary
+// phase's ChunkRatio and the main phase's Ratio are filled in.
+func CanaryPhases(canary, main Phase) []Phase {
+	canary.Name, canary.ChunkRatio = "canary", Ratio(1)
+	main.Name, main.Ratio = "main", Ratio(1)
+	return []Phase{canary, main}
+}
+
+// ParsePhase parses a phase in the form used by the `-phase` CLI flag: a
+// comma-separated list of key=value pairs, e.g.
+// "name=canary,ratio=5%,chunk-ratio=1,timeout-tolerance=0.5". Every field but
+// the name, failure-count and bake (in seconds) is a Quantity.
+func ParsePhase(s string) (p Phase, err error) {
+	p.ChunkRatio = Ratio(1)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("invalid phase field %q: expected key=value", field)
+		}
+		key, value := kv[0], kv[1]
+		if key == "name" {
+			p.Name = value
+			continue
+		}
+		if key == "bake" {
+			var secs int
+			if secs, err = strconv.Atoi(value); err != nil {
+				return p, fmt.Errorf("invalid value for phase field %q: %s", key, err)
+			}
+			p.Bake = time.Duration(secs) * time.Second
+			continue
+		}
+		if key == "failure-count" {
+			if p.FailureCount, err = strconv.Atoi(value); err != nil {
+				return p, fmt.Errorf("invalid value for phase field %q: %s", key, err)
+			}
+			continue
+		}
+		var q Quantity
+		if q, err = parseQuantity(value); err != nil {
+			return p, fmt.Errorf("invalid value for phase field %q: %s", key, err)
+		}
+		switch key {
+		case "ratio":
+			p.Ratio = q
+		case "chunk-ratio":
+			p.ChunkRatio = q
+		case "timeout-tolerance":
+			p.TimeoutTolerance = q
+		case "failure-tolerance":
+			p.FailureTolerance = q
+		default:
+			return p, fmt.Errorf("unknown phase field %q", key)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,50 @@
+package rollout
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPhase(t *testing.T) {
+
+	Convey("Parsing a phase", t, func() {
+		Convey("should read every field", func() {
+			p, err := ParsePhase("name=early,ratio=0.05,chunk-ratio=0.5,timeout-tolerance=0.2,failure-tolerance=0.1,failure-count=2")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, Phase{
+				Name:             "early",
+				Ratio:            Ratio(0.05),
+				ChunkRatio:       Ratio(0.5),
+				TimeoutTolerance: Ratio(0.2),
+				FailureTolerance: Ratio(0.1),
+				FailureCount:     2,
+			})
+		})
+		Convey("should restart the whole phase concurrently by default", func() {
+			p, err := ParsePhase("ratio=0.1")
+			So(err, ShouldBeNil)
+			So(p.ChunkRatio, ShouldResemble, Ratio(1))
+		})
+		Convey("should reject unknown fields", func() {
+			_, err := ParsePhase("ratio=0.1,bogus=1")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("should reject malformed values", func() {
+			_, err := ParsePhase("ratio=lots")
+			So(err, ShouldNotBeNil)
+			_, err = ParsePhase("failure-count=0.5")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("The canary phases", t, func() {
+		phases := CanaryPhases(Phase{Ratio: Ratio(0.1), ChunkRatio: Ratio(0.5)}, Phase{Ratio: Ratio(0.5), ChunkRatio: Ratio(0.3)})
+		Convey("should restart the canaries at once, then everything else", func() {
+			So(phases, ShouldResemble, []Phase{
+				{Name: "canary", Ratio: Ratio(0.1), ChunkRatio: Ratio(1)},
+				{Name: "main", Ratio: Ratio(1), ChunkRatio: Ratio(0.3)},
+			})
+		})
+	})
+}
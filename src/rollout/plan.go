@@ -0,0 +1,72 @@
+package rollout
+
+// Plan is the fully-resolved description of what a Deployment would do,
+// printed by `-dry-run`. Patterns and Excludes are left for the caller to
+// fill in, since the Deployment is only given the services they matched.
+type Plan struct {
+	Patterns []string `json:"patterns"`
+	Excludes []string `json:"excludes"`
+	Services int      `json:"services"`
+	Timeout  int      `json:"timeout"`
+	// Timeouts are the timeouts, in seconds, of services that override
+	// Timeout.
+	Timeouts map[string]int `json:"timeouts,omitempty"`
+	Canaries []string       `json:"canaries"`
+	Phases   []PlanPhase    `json:"phases"`
+}
+
+// PlanPhase describes a single phase of a Plan. The permitted counts are for
+// this phase alone; the total counts include every earlier phase.
+type PlanPhase struct {
+	Name                   string   `json:"name"`
+	Services               []string `json:"services"`
+	Concurrency            int      `json:"concurrency"`
+	TimeoutsPermitted      int      `json:"timeouts_permitted"`
+	FailuresPermitted      int      `json:"failures_permitted"`
+	TotalTimeoutsPermitted int      `json:"total_timeouts_permitted"`
+	TotalFailuresPermitted int      `json:"total_failures_permitted"`
+	// Bake is how many seconds the phase bakes for before the next begins.
+	Bake int `json:"bake"`
+}
+
+// Plan returns the resolved rollout plan without restarting anything.
+func (d *Deployment) Plan() Plan {
+	plan := Plan{
+		Services: d.numServices,
+		Timeout:  int(d.timeout.Seconds()),
+		Canaries: []string{},
+		Phases:   []PlanPhase{},
+	}
+	var prevTimeouts, prevFailures int
+	for _, p := range d.phases {
+		services := p.services
+		if services == nil {
+			services = []string{}
+		}
+		plan.Phases = append(plan.Phases, PlanPhase{
+			Name:                   p.Name,
+			Services:               services,
+			Concurrency:            p.concurrency,
+			TimeoutsPermitted:      p.timeoutsPermitted - prevTimeouts,
+			FailuresPermitted:      p.failuresPermitted - prevFailures,
+			TotalTimeoutsPermitted: p.timeoutsPermitted,
+			TotalFailuresPermitted: p.failuresPermitted,
+		})
+		if p != d.phases[len(d.phases)-1] {
+			plan.Phases[len(plan.Phases)-1].Bake = int(p.bake.Seconds())
+		}
+		prevTimeouts, prevFailures = p.timeoutsPermitted, p.failuresPermitted
+	}
+	for svc, o := range d.opts.Overrides {
+		if o.Timeout > 0 {
+			if plan.Timeouts == nil {
+				plan.Timeouts = make(map[string]int)
+			}
+			plan.Timeouts[svc] = int(o.Timeout.Seconds())
+		}
+	}
+	if len(d.phases) > 1 {
+		plan.Canaries = plan.Phases[0].Services
+	}
+	return plan
+}
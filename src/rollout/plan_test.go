@@ -0,0 +1,51 @@
+package rollout
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPlan(t *testing.T) {
+
+	Convey("Planning a deployment", t, func() {
+		c := config{
+			CanaryRatio:            Ratio(0.2),
+			CanaryTimeoutTolerance: Ratio(0.5),
+			ChunkRatio:             Ratio(0.5),
+			TimeoutTolerance:       Ratio(0.25),
+			Timeout:                30,
+		}
+		depl := NewDeployment([]string{"a", "b", "c", "d", "e", "f"}, c.options())
+		plan := depl.Plan()
+
+		Convey("should resolve canaries, concurrency and tolerances", func() {
+			So(plan.Services, ShouldEqual, 6)
+			So(plan.Timeout, ShouldEqual, 30)
+			So(plan.Canaries, ShouldResemble, []string{"a", "b"})
+			So(plan.Phases, ShouldResemble, []PlanPhase{
+				{
+					Name:                   "canary",
+					Services:               []string{"a", "b"},
+					Concurrency:            2,
+					TimeoutsPermitted:      1,
+					TotalTimeoutsPermitted: 1,
+				},
+				{
+					Name:                   "main",
+					Services:               []string{"c", "d", "e", "f"},
+					Concurrency:            2,
+					TimeoutsPermitted:      1,
+					TotalTimeoutsPermitted: 2,
+				},
+			})
+		})
+	})
+
+	Convey("Planning a deployment without canaries", t, func() {
+		depl := NewDeployment([]string{"a", "b"}, config{ChunkRatio: Ratio(1)}.options())
+		plan := depl.Plan()
+		So(plan.Canaries, ShouldResemble, []string{})
+		So(plan.Phases[0].Services, ShouldResemble, []string{})
+	})
+}
@@ -0,0 +1,143 @@
+package rollout
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Quantity is a number of services, given as a ratio or percentage of some
+// total (e.g. "0.2" or "20%") or as an absolute count (e.g. "3x"), and
+// optionally clamped (e.g. "20%:2..10" for 20%, but at least 2 and at most 10).
+//
+// A bare "1" is a ratio, meaning every service, as it always has been, so
+// exactly one service is "1x". Other whole numbers without the "x" are read as
+// counts too.
+type Quantity struct {
+	Ratio float64
+	// Count is used instead of Ratio if Absolute is set.
+	Count    int
+	Absolute bool
+	// Min and Max clamp the resolved number. Max is ignored if zero.
+	Min int
+	Max int
+}
+
+// Ratio returns the Quantity for a plain ratio, e.g. 0.2 for 20%.
+func Ratio(r float64) Quantity {
+	return Quantity{Ratio: r}
+}
+
+// Count returns the Quantity for an absolute number of services.
+func Count(n int) Quantity {
+	return Quantity{Count: n, Absolute: true}
+}
+
+// Of resolves the quantity against n services. Ratios are rounded up to the
+// nearest service, unless they're zero.
+func (q Quantity) Of(n int) int {
+	v := q.Count
+	if !q.Absolute {
+		// allow for floating-point error, so that e.g. 7% of 100 is 7, not 8.
+		v = int(math.Ceil(q.Ratio*float64(n) - 1e-9))
+	}
+	if v < q.Min {
+		v = q.Min
+	}
+	if q.Max > 0 && v > q.Max {
+		v = q.Max
+	}
+	return v
+}
+
+func (q Quantity) String() string {
+	var s string
+	if q.Absolute {
+		s = strconv.Itoa(q.Count) + "x"
+	} else {
+		s = strconv.FormatFloat(q.Ratio, 'f', -1, 64)
+		if q.Ratio > 1 && !strings.Contains(s, ".") {
+			// keep it from reading as a count.
+			s += ".0"
+		}
+	}
+	if q.Min > 0 || q.Max > 0 {
+		s += ":"
+		if q.Min > 0 {
+			s += strconv.Itoa(q.Min)
+		}
+		s += ".."
+		if q.Max > 0 {
+			s += strconv.Itoa(q.Max)
+		}
+	}
+	return s
+}
+
+// parseQuantity parses a Quantity in the form accepted by the CLI flags:
+// a ratio, percentage or count ("3x", or "3"), optionally followed by
+// ":min..max", where either bound may be omitted.
+func parseQuantity(s string) (q Quantity, err error) {
+	value, clamp := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		value, clamp = s[:i], s[i+1:]
+	}
+
+	switch {
+	case strings.HasSuffix(value, "%"):
+		var pct float64
+		if pct, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64); err != nil {
+			return q, fmt.Errorf("invalid percentage %q", value)
+		}
+		q.Ratio = pct / 100
+	case strings.HasSuffix(value, "x"):
+		if q.Count, err = strconv.Atoi(strings.TrimSuffix(value, "x")); err != nil {
+			return q, fmt.Errorf("invalid count %q", value)
+		}
+		q.Absolute = true
+	case value != "1" && !strings.ContainsAny(value, ".eE"):
+		if q.Count, err = strconv.Atoi(value); err != nil {
+			return q, fmt.Errorf("invalid count %q", value)
+		}
+		q.Absolute = true
+	default:
+		if q.Ratio, err = strconv.ParseFloat(value, 64); err != nil {
+			return q, fmt.Errorf("invalid ratio %q", value)
+		}
+	}
+	if q.Ratio < 0 || q.Count < 0 {
+		return q, fmt.Errorf("%q is negative", value)
+	}
+
+	if clamp != "" {
+		bounds := strings.SplitN(clamp, "..", 2)
+		if len(bounds) != 2 {
+			return q, fmt.Errorf("invalid bounds %q: expected min..max", clamp)
+		}
+		if bounds[0] != "" {
+			if q.Min, err = strconv.Atoi(bounds[0]); err != nil || q.Min < 0 {
+				return q, fmt.Errorf("invalid minimum %q", bounds[0])
+			}
+		}
+		if bounds[1] != "" {
+			if q.Max, err = strconv.Atoi(bounds[1]); err != nil || q.Max < 1 {
+				return q, fmt.Errorf("invalid maximum %q", bounds[1])
+			}
+		}
+		if q.Max > 0 && q.Min > q.Max {
+			return q, fmt.Errorf("invalid bounds %q: minimum is more than maximum", clamp)
+		}
+	}
+	return q, nil
+}
+
+// Set implements flag.Value, so that a Quantity can be used as a CLI flag.
+func (q *Quantity) Set(s string) error {
+	parsed, err := parseQuantity(s)
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
@@ -0,0 +1,80 @@
+package rollout
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuantity(t *testing.T) {
+
+	Convey("Parsing a quantity", t, func() {
+		parse := func(s string) Quantity {
+			q, err := parseQuantity(s)
+			So(err, ShouldBeNil)
+			return q
+		}
+
+		Convey("should read ratios, percentages and counts", func() {
+			So(parse("0.2"), ShouldResemble, Ratio(0.2))
+			So(parse("25%"), ShouldResemble, Ratio(0.25))
+			So(parse("3x"), ShouldResemble, Count(3))
+			So(parse("1e-4"), ShouldResemble, Ratio(0.0001))
+		})
+
+		Convey("should read a bare 1 as every service, as before", func() {
+			So(parse("1"), ShouldResemble, Ratio(1))
+			So(parse("1.0"), ShouldResemble, Ratio(1))
+			So(parse("1x"), ShouldResemble, Count(1))
+			So(parse("1x").Of(100), ShouldEqual, 1)
+		})
+
+		Convey("should read other whole numbers as counts", func() {
+			So(parse("3"), ShouldResemble, Count(3))
+			So(parse("0"), ShouldResemble, Count(0))
+		})
+
+		Convey("should read bounds", func() {
+			So(parse("20%:2..10"), ShouldResemble, Quantity{Ratio: 0.2, Min: 2, Max: 10})
+			So(parse("0.5:2.."), ShouldResemble, Quantity{Ratio: 0.5, Min: 2})
+			So(parse("1x:..1"), ShouldResemble, Quantity{Count: 1, Absolute: true, Max: 1})
+		})
+
+		Convey("should reject nonsense", func() {
+			for _, s := range []string{"", "lots", "-1", "-10%", "x%", "x", "-1x", "1.5x", "20%:2", "20%:a..b", "20%:10..2", "20%:..0"} {
+				_, err := parseQuantity(s)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("should round-trip through String", func() {
+			for _, s := range []string{"0.2", "3x", "1x", "1", "2.0", "0.2:2..10", "0.5:..4", "2x:..4"} {
+				So(parse(s).String(), ShouldEqual, s)
+			}
+		})
+	})
+
+	Convey("Resolving a quantity", t, func() {
+		Convey("should round ratios up, unless they're zero", func() {
+			So(Ratio(0.2).Of(11), ShouldEqual, 3)
+			So(Ratio(0.0001).Of(11), ShouldEqual, 1)
+			So(Ratio(0).Of(11), ShouldEqual, 0)
+		})
+
+		Convey("should not round up floating-point error", func() {
+			So(Ratio(0.07).Of(100), ShouldEqual, 7)
+		})
+
+		Convey("should use counts as they are", func() {
+			So(Count(3).Of(100), ShouldEqual, 3)
+			So(Count(3).Of(2), ShouldEqual, 3)
+		})
+
+		Convey("should clamp to the bounds", func() {
+			q := Quantity{Ratio: 0.2, Min: 2, Max: 10}
+			So(q.Of(5), ShouldEqual, 2)
+			So(q.Of(25), ShouldEqual, 5)
+			So(q.Of(100), ShouldEqual, 10)
+		})
+	})
+}
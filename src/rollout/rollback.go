@@ -0,0 +1,76 @@
+package rollout
+
+import (
+	"context"
+	"time"
+)
+
+// RollbackPhase is the name of the single phase of a rollback Deployment.
+const RollbackPhase = "rollback"
+
+// NewRollback stops the failed Deployment d and returns a Deployment that
+// rolls back every service d restarted, by restarting each again with
+// restarter (which should put the previous release back in place first), with
+// the concurrency of the phase that failed. If restarter is nil, d's own is
+// used.
+func NewRollback(d *Deployment, restarter Restarter) *Deployment {
+	d.Abort()
+	d.workers.Wait()
+
+	services := d.Restarted()
+	opts := d.opts
+	opts.Phases = []Phase{{
+		Name:       RollbackPhase,
+		Ratio:      Ratio(1),
+		ChunkRatio: Ratio(1),
+		// a rollback carries on however many services fail, so that as many as
+		// possible are rolled back.
+		TimeoutTolerance: Ratio(1),
+		FailureTolerance: Ratio(1),
+	}}
+	if restarter != nil {
+		opts.Restarter = restarter
+	}
+	rb := NewDeployment(services, opts)
+	rb.rollback = true
+	if d.phase != nil && d.phase.concurrency < rb.phases[0].concurrency {
+		rb.phases[0].concurrency = d.phase.concurrency
+	}
+	return rb
+}
+
+// Rollback is Run for a Deployment returned by NewRollback. It returns
+// ErrRollbackFailed unless every service was rolled back and restarted
+// successfully, or ErrAborted if ctx is cancelled first.
+func (d *Deployment) Rollback(ctx context.Context) (err error) {
+	defer d.abortWhenDone(ctx)()
+	start := time.Now()
+	d.emit(Event{Event: EventRollbackStarted})
+	defer func() {
+		if err != nil {
+			// as for Run, nothing's restarted after Rollback returns.
+			d.Abort()
+			d.workers.Wait()
+		}
+		if d.opts.Statsd != nil {
+			d.opts.Statsd.Timer("rollback.duration", time.Since(start), []string{statusTag(err)}, 1)
+		}
+		e := Event{Event: EventRollbackFinished, Duration: time.Since(start).Seconds()}
+		counts := d.tally.snapshot()
+		e.Counts = &counts
+		if err != nil {
+			e.Error = err.Error()
+		}
+		d.emit(e)
+	}()
+
+	p := d.phases[0]
+	d.phase = p
+	if err = d.restartServices(p.services, p.concurrency, p.failuresPermitted, p.timeoutsPermitted, d.allComplete); err != nil {
+		return
+	}
+	if d.tally.snapshot().Successes < d.numServices {
+		return ErrRollbackFailed
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package rollout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRollback(t *testing.T) {
+
+	Convey("Rolling back a failed deployment", t, func() {
+		c := config{
+			CanaryRatio: Ratio(0.5),
+			ChunkRatio:  Ratio(0.0001),
+			Timeout:     1,
+		}
+		c.restartSvr = func(svr *SvRestarter) error {
+			if svr.Service == "b" {
+				return alwaysFail(svr)
+			}
+			return nil
+		}
+		depl := NewDeployment([]string{"a", "b", "c", "d"}, c.options())
+		So(depl.Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+		rb := NewRollback(depl, nil)
+
+		Convey("should roll back only the services that were restarted", func() {
+			So(rb.phases, ShouldHaveLength, 1)
+			So(rb.phases[0].services, ShouldResemble, []string{"a", "b"})
+			So(rb.phases[0].concurrency, ShouldEqual, 2)
+		})
+
+		Convey("should restart every service, however many fail", func() {
+			var restarted []string
+			rb.opts.restartSvr = func(svr *SvRestarter) error {
+				restarted = append(restarted, svr.Service+" "+svr.phase)
+				return ErrRestartTimeout{Service: svr.Service}
+			}
+			rb.phases[0].concurrency = 1
+			So(rb.Rollback(context.Background()), ShouldEqual, ErrRollbackFailed)
+			So(restarted, ShouldResemble, []string{"a rollback", "b rollback"})
+		})
+
+		Convey("should succeed when every service is rolled back", func() {
+			rb.opts.restartSvr = func(svr *SvRestarter) error {
+				svr.tally.record(nil)
+				return nil
+			}
+			So(rb.Rollback(context.Background()), ShouldBeNil)
+		})
+
+		Convey("should fail when any service isn't rolled back", func() {
+			rb.opts.restartSvr = func(svr *SvRestarter) error {
+				err := error(nil)
+				if svr.Service == "a" {
+					err = alwaysFail(svr)
+				}
+				svr.tally.record(err)
+				return err
+			}
+			So(rb.Rollback(context.Background()), ShouldEqual, ErrRollbackFailed)
+		})
+
+		Convey("should restart with the given Restarter", func() {
+			// restart for real, with the Restarter.
+			depl.opts.restartSvr = nil
+			var restarted []string
+			rb := NewRollback(depl, RestarterFunc(func(s string, t time.Duration, a chan<- struct{}) error {
+				restarted = append(restarted, s)
+				close(a)
+				return nil
+			}))
+			rb.phases[0].concurrency = 1
+			So(rb.Rollback(context.Background()), ShouldBeNil)
+			So(restarted, ShouldResemble, []string{"a", "b"})
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package rollout
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// The states a runit service can be in, as recorded in byte 19 of
+// supervise/status.
+const (
+	stateDown   = 0
+	stateRun    = 1
+	stateFinish = 2
+)
+
+// superviseStatus is the decoded contents of a service's supervise/status
+// file, which runsv rewrites on every state change.
+type superviseStatus struct {
+	// Since is when the service last changed state.
+	Since   time.Time
+	Pid     int
+	Paused  bool
+	WantUp  bool
+	GotTerm bool
+	State   int
+}
+
+// Up reports whether the service's process is running.
+func (st superviseStatus) Up() bool {
+	return st.State == stateRun && st.Pid != 0
+}
+
+func (st superviseStatus) String() string {
+	state := "down"
+	switch st.State {
+	case stateRun:
+		state = "run"
+	case stateFinish:
+		state = "finish"
+	}
+	if st.Pid != 0 {
+		return fmt.Sprintf("%s: (pid %d) %ds", state, st.Pid, int(time.Since(st.Since).Seconds()))
+	}
+	return fmt.Sprintf("%s: %ds", state, int(time.Since(st.Since).Seconds()))
+}
+
+const (
+	superviseStatusLen = 20
+	// TAI64 labels are offset by 2^62, and runit's clock is 10s ahead of UNIX
+	// time.
+	tai64Offset = 1<<62 + 10
+)
+
+// parseSuperviseStatus decodes runsv's binary status record: a TAI64N
+// timestamp, the pid (little-endian), then the paused, want, got-TERM and
+// state bytes.
+func parseSuperviseStatus(b []byte) (st superviseStatus, err error) {
+	if len(b) != superviseStatusLen {
+		return st, fmt.Errorf("supervise/status is %d bytes, expected %d", len(b), superviseStatusLen)
+	}
+	secs := binary.BigEndian.Uint64(b[0:8])
+	nanos := binary.BigEndian.Uint32(b[8:12])
+	st.Since = time.Unix(int64(secs-tai64Offset), int64(nanos))
+	st.Pid = int(binary.LittleEndian.Uint32(b[12:16]))
+	st.Paused = b[16] != 0
+	st.WantUp = b[17] == 'u'
+	st.GotTerm = b[18] != 0
+	st.State = int(b[19])
+	return st, nil
+}
+
+func readSuperviseStatus(dir string) (superviseStatus, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "supervise", "status"))
+	if err != nil {
+		return superviseStatus{}, err
+	}
+	return parseSuperviseStatus(b)
+}
+
+// superviseControl writes control characters (e.g. "u" for up, "t" for TERM)
+// to the service's supervise/control FIFO.
+func superviseControl(dir string, commands string) error {
+	path := filepath.Join(dir, "supervise", "control")
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.ENXIO {
+			return fmt.Errorf("runsv not running")
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write([]byte(commands))
+	return err
+}
+
+// restartPollInterval is how often supervise/status is re-read while waiting
+// for a service to come back up.
+var restartPollInterval = 100 * time.Millisecond
+
+// Runit is a Restarter for the services in a runit service directory.
+type Runit struct {
+	Svdir string
+}
+
+// Restart restarts the service as `sv -w <timeout> restart` would.
+func (r Runit) Restart(service string, timeout time.Duration, started chan<- struct{}) error {
+	return restartService(filepath.Join(r.Svdir, service), service, timeout, started)
+}
+
+// restartService does what `sv -w <timeout> restart` does: it sends TERM, CONT
+// and up to the service in dir, then waits for runsv to report a new process
+// running and for the service's check script (if any) to pass. It returns
+// ErrRestartTimeout if that doesn't happen within the timeout, and
+// ErrRestartFailed for any other problem.
+func restartService(dir, service string, timeout time.Duration, started chan<- struct{}) error {
+	before, err := readSuperviseStatus(dir)
+	if err == nil {
+		err = superviseControl(dir, "tcu")
+	}
+	// Once the control characters are written, runsv will restart the service
+	// whether or not we stick around to watch.
+	close(started)
+	if err != nil {
+		return ErrRestartFailed{Service: service, Message: err.Error()}
+	}
+
+	deadline := time.Now().Add(timeout)
+	var st superviseStatus
+	for {
+		if st, err = readSuperviseStatus(dir); err != nil {
+			return ErrRestartFailed{Service: service, Message: err.Error()}
+		}
+		restarted := st.Pid != before.Pid || st.Since.After(before.Since)
+		if st.Up() && restarted && runCheckScript(dir) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrRestartTimeout{Service: service}
+		}
+		time.Sleep(restartPollInterval)
+	}
+}
+
+// runCheckScript runs the service's `check` script, as `sv` does, reporting
+// whether it passed. Services without a check script always pass.
+func runCheckScript(dir string) bool {
+	check := filepath.Join(dir, "check")
+	if _, err := os.Stat(check); err != nil {
+		return true
+	}
+	cmd := exec.Command(check)
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
+
+// serviceStatus reads the runit status of service in svdir.
+func serviceStatus(svdir, service string) (superviseStatus, error) {
+	return readSuperviseStatus(filepath.Join(svdir, service))
+}
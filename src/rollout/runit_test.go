@@ -0,0 +1,152 @@
+package rollout
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func encodeSuperviseStatus(st superviseStatus) []byte {
+	b := make([]byte, superviseStatusLen)
+	binary.BigEndian.PutUint64(b[0:8], uint64(st.Since.Unix())+tai64Offset)
+	binary.BigEndian.PutUint32(b[8:12], uint32(st.Since.Nanosecond()))
+	binary.LittleEndian.PutUint32(b[12:16], uint32(st.Pid))
+	if st.Paused {
+		b[16] = 1
+	}
+	b[17] = 'd'
+	if st.WantUp {
+		b[17] = 'u'
+	}
+	if st.GotTerm {
+		b[18] = 1
+	}
+	b[19] = byte(st.State)
+	return b
+}
+
+// fakeService creates a service directory with a supervise/control FIFO and a
+// supervise/status file, like the ones runsv maintains.
+func fakeService(st superviseStatus) string {
+	dir, err := ioutil.TempDir("", "sv-rollout-service")
+	if err != nil {
+		panic(err)
+	}
+	os.Mkdir(filepath.Join(dir, "supervise"), 0755)
+	if err := syscall.Mkfifo(filepath.Join(dir, "supervise", "control"), 0600); err != nil {
+		panic(err)
+	}
+	writeFakeStatus(dir, st)
+	return dir
+}
+
+func writeFakeStatus(dir string, st superviseStatus) {
+	ioutil.WriteFile(filepath.Join(dir, "supervise", "status"), encodeSuperviseStatus(st), 0644)
+}
+
+// fakeRunsv reads control characters from the service's FIFO, and calls
+// onControl with each batch.
+func fakeRunsv(dir string, onControl func(string)) (stop func()) {
+	f, err := os.OpenFile(filepath.Join(dir, "supervise", "control"), os.O_RDWR, 0)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			onControl(string(buf[:n]))
+		}
+	}()
+	return func() { f.Close() }
+}
+
+func TestRunit(t *testing.T) {
+	since := time.Unix(1500000000, 12345)
+
+	Convey("Parsing supervise/status", t, func() {
+		Convey("should decode every field", func() {
+			want := superviseStatus{Since: since, Pid: 70000, WantUp: true, GotTerm: true, State: stateRun}
+			st, err := parseSuperviseStatus(encodeSuperviseStatus(want))
+			So(err, ShouldBeNil)
+			So(st.Since.Equal(since), ShouldBeTrue)
+			st.Since = since
+			So(st, ShouldResemble, want)
+			So(st.Up(), ShouldBeTrue)
+		})
+		Convey("should reject a truncated record", func() {
+			_, err := parseSuperviseStatus(make([]byte, 18))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Restarting a service through its supervise directory", t, func() {
+		defer func() { restartPollInterval = 100 * time.Millisecond }()
+		restartPollInterval = 5 * time.Millisecond
+		dir := fakeService(superviseStatus{Since: since, Pid: 100, WantUp: true, State: stateRun})
+		defer os.RemoveAll(dir)
+
+		Convey("should succeed once runsv reports a new pid", func() {
+			controls := make(chan string, 1)
+			stop := fakeRunsv(dir, func(c string) {
+				controls <- c
+				writeFakeStatus(dir, superviseStatus{Since: since.Add(time.Second), Pid: 101, WantUp: true, State: stateRun})
+			})
+			defer stop()
+			a := make(chan struct{})
+			err := restartService(dir, "svc", time.Second, a)
+			So(err, ShouldBeNil)
+			So(<-controls, ShouldEqual, "tcu")
+			_, open := <-a
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("should find the service within the service directory", func() {
+			stop := fakeRunsv(dir, func(c string) {
+				writeFakeStatus(dir, superviseStatus{Since: since.Add(time.Second), Pid: 101, WantUp: true, State: stateRun})
+			})
+			defer stop()
+			err := Runit{Svdir: filepath.Dir(dir)}.Restart(filepath.Base(dir), time.Second, make(chan struct{}))
+			So(err, ShouldBeNil)
+			st, err := serviceStatus(filepath.Dir(dir), filepath.Base(dir))
+			So(err, ShouldBeNil)
+			So(st.Pid, ShouldEqual, 101)
+		})
+
+		Convey("should wait for the check script to pass", func() {
+			stop := fakeRunsv(dir, func(c string) {
+				writeFakeStatus(dir, superviseStatus{Since: since.Add(time.Second), Pid: 101, WantUp: true, State: stateRun})
+			})
+			defer stop()
+			ioutil.WriteFile(filepath.Join(dir, "check"), []byte("#!/bin/sh\nexit 1\n"), 0755)
+			err := restartService(dir, "svc", 50*time.Millisecond, make(chan struct{}))
+			So(err, ShouldResemble, ErrRestartTimeout{Service: "svc"})
+		})
+
+		Convey("should time out if the process is never replaced", func() {
+			stop := fakeRunsv(dir, func(c string) {})
+			defer stop()
+			err := restartService(dir, "svc", 50*time.Millisecond, make(chan struct{}))
+			So(err, ShouldResemble, ErrRestartTimeout{Service: "svc"})
+		})
+
+		Convey("should fail if runsv isn't running", func() {
+			err := restartService(dir, "svc", time.Second, make(chan struct{}))
+			So(err, ShouldResemble, ErrRestartFailed{Service: "svc", Message: "runsv not running"})
+		})
+
+		Convey("should fail if there's no such service", func() {
+			err := restartService(filepath.Join(dir, "nope"), "nope", time.Second, make(chan struct{}))
+			So(err, ShouldHaveSameTypeAs, ErrRestartFailed{})
+		})
+	})
+}
@@ -0,0 +1,41 @@
+package rollout
+
+import (
+	"fmt"
+	"time"
+)
+
+// stabilityPollInterval is how often a service's status is checked while
+// waiting for it to prove stable.
+var stabilityPollInterval = time.Second
+
+// waitStable watches the service's runit status for s.stableFor after a
+// restart, returning ErrRestartUnstable if the service goes down or is
+// restarted by runit (i.e. its pid changes) within that window.
+func (s *SvRestarter) waitStable() error {
+	initial, err := s.status(s.svdir, s.Service)
+	if err != nil {
+		return ErrRestartUnstable{Service: s.Service, Message: err.Error()}
+	}
+	if !initial.Up() {
+		return ErrRestartUnstable{Service: s.Service, Message: "service is down"}
+	}
+
+	deadline := time.Now().Add(s.stableFor)
+	for time.Now().Before(deadline) {
+		time.Sleep(stabilityPollInterval)
+		st, err := s.status(s.svdir, s.Service)
+		if err != nil {
+			return ErrRestartUnstable{Service: s.Service, Message: err.Error()}
+		}
+		switch {
+		case !st.Up():
+			return ErrRestartUnstable{Service: s.Service, Message: "service went down"}
+		case st.Pid != initial.Pid:
+			return ErrRestartUnstable{Service: s.Service, Message: fmt.Sprintf("pid changed from %d to %d", initial.Pid, st.Pid)}
+		case !st.Since.Equal(initial.Since):
+			return ErrRestartUnstable{Service: s.Service, Message: fmt.Sprintf("state changed to %s", st)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package rollout
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStability(t *testing.T) {
+
+	Convey("Waiting for a service to prove stable", t, func() {
+		defer func() { stabilityPollInterval = time.Second }()
+		stabilityPollInterval = 5 * time.Millisecond
+		svr := NewSvRestarter("svc", 1, 1, time.Second)
+		svr.stableFor = 20 * time.Millisecond
+
+		since := time.Now()
+		stubStatuses := func(statuses ...superviseStatus) {
+			calls := 0
+			svr.status = func(svdir, service string) (superviseStatus, error) {
+				st := statuses[calls]
+				if calls < len(statuses)-1 {
+					calls++
+				}
+				return st, nil
+			}
+		}
+
+		Convey("should succeed if the pid stays the same", func() {
+			stubStatuses(
+				superviseStatus{State: stateRun, Pid: 10, Since: since},
+				superviseStatus{State: stateRun, Pid: 10, Since: since},
+			)
+			So(svr.waitStable(), ShouldBeNil)
+		})
+		Convey("should fail if the pid changes", func() {
+			stubStatuses(
+				superviseStatus{State: stateRun, Pid: 10, Since: since},
+				superviseStatus{State: stateRun, Pid: 11, Since: since.Add(time.Second)},
+			)
+			So(svr.waitStable(), ShouldHaveSameTypeAs, ErrRestartUnstable{})
+		})
+		Convey("should fail if the service goes down", func() {
+			stubStatuses(
+				superviseStatus{State: stateRun, Pid: 10, Since: since},
+				superviseStatus{State: stateDown, Since: since.Add(time.Second)},
+			)
+			So(svr.waitStable(), ShouldHaveSameTypeAs, ErrRestartUnstable{})
+		})
+		Convey("should fail if the status can't be read", func() {
+			svr.status = func(svdir, service string) (superviseStatus, error) {
+				return superviseStatus{}, errors.New("no such service")
+			}
+			So(svr.waitStable(), ShouldHaveSameTypeAs, ErrRestartUnstable{})
+		})
+	})
+}
@@ -0,0 +1,99 @@
+package rollout
+
+import (
+	"fmt"
+	"time"
+)
+
+// StatsdClient is the part of a dogstatsd client a Deployment sends metrics
+// and events to. *dogstatsd.Client from github.com/Shopify/go-dogstatsd
+// implements it.
+type StatsdClient interface {
+	Timer(name string, value time.Duration, tags []string, rate float64) error
+	Count(name string, value int64, tags []string, rate float64) error
+	Info(title, text string, tags []string) error
+	Success(title, text string, tags []string) error
+	Error(title, text string, tags []string) error
+}
+
+// statusTag returns the status tag for the result of a rollout, rollback or
+// phase.
+func statusTag(err error) string {
+	switch err {
+	case nil:
+		return "status:success"
+	case ErrAborted, ErrNotApproved:
+		return "status:aborted"
+	}
+	return "status:failure"
+}
+
+// restartStatusTag returns the status tag for the result of a restart that
+// failed with err, or the result of the health check or stability check that
+// followed a successful one (checked).
+func restartStatusTag(err error, checked bool) string {
+	switch err.(type) {
+	case nil:
+		return "status:success"
+	case ErrRestartTimeout:
+		return "status:timeout"
+	case ErrRestartUnstable:
+		return "status:unstable"
+	case ErrRestartPreempted:
+		return "status:preempted"
+	}
+	if checked {
+		return "status:unhealthy"
+	}
+	return "status:failed"
+}
+
+// sendRolloutStarted sends a datadog event for the start of the rollout.
+func (d *Deployment) sendRolloutStarted() {
+	if d.opts.Statsd == nil {
+		return
+	}
+	d.opts.Statsd.Info("sv-rollout started",
+		fmt.Sprintf("restarting %d services in %d phases", d.numServices, len(d.phases)), nil)
+}
+
+// sendRolloutFinished sends the rollout's duration and counts, and a datadog
+// event for its end.
+func (d *Deployment) sendRolloutFinished(duration time.Duration, err error) {
+	if d.opts.Statsd == nil {
+		return
+	}
+	tags := []string{statusTag(err)}
+	d.opts.Statsd.Timer("rollout.duration", duration, tags, 1)
+	counts := d.tally.snapshot()
+	d.opts.Statsd.Count("rollout.successes", int64(counts.Successes), tags, 1)
+	d.opts.Statsd.Count("rollout.timeouts", int64(counts.Timeouts), tags, 1)
+	d.opts.Statsd.Count("rollout.failures", int64(counts.Failures), tags, 1)
+	d.opts.Statsd.Count("rollout.unstable", int64(counts.Unstable), tags, 1)
+	d.opts.Statsd.Count("rollout.preempted", int64(counts.Preempted), tags, 1)
+
+	text := fmt.Sprintf("%d of %d services restarted successfully, %d timed out, %d failed, %d were unstable, %d were preempted",
+		counts.Successes, d.numServices, counts.Timeouts, counts.Failures, counts.Unstable, counts.Preempted)
+	if err == nil {
+		d.opts.Statsd.Success("sv-rollout succeeded", text, tags)
+	} else {
+		d.opts.Statsd.Error("sv-rollout failed", fmt.Sprintf("%s: %s", err, text), tags)
+	}
+}
+
+// sendPhaseFinished sends the duration of phase p, the ith, including its
+// bake, and, for the first of several phases, whether the canaries passed.
+func (d *Deployment) sendPhaseFinished(i int, p *deploymentPhase, duration time.Duration, err error) {
+	if d.opts.Statsd == nil {
+		return
+	}
+	tags := []string{"phase:" + p.Name, statusTag(err)}
+	d.opts.Statsd.Timer("phase.duration", duration, tags, 1)
+	if i == 0 && len(d.phases) > 1 && err != ErrAborted {
+		verdict := "verdict:passed"
+		if err != nil {
+			verdict = "verdict:failed"
+		}
+		d.opts.Statsd.Count("canary.verdict", 1, []string{"phase:" + p.Name, verdict}, 1)
+	}
+}
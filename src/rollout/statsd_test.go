@@ -0,0 +1,135 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// statsdRecorder is a StatsdClient that records what it's sent, as
+// "kind name tags" lines, from any goroutine.
+type statsdRecorder struct {
+	sync.Mutex
+	sent []string
+}
+
+func (r *statsdRecorder) add(kind, name string, tags []string) error {
+	r.Lock()
+	defer r.Unlock()
+	r.sent = append(r.sent, fmt.Sprintf("%s %s %s", kind, name, strings.Join(tags, ",")))
+	return nil
+}
+
+func (r *statsdRecorder) Timer(name string, value time.Duration, tags []string, rate float64) error {
+	return r.add("timer", name, tags)
+}
+
+func (r *statsdRecorder) Count(name string, value int64, tags []string, rate float64) error {
+	return r.add("count", name, append(tags, fmt.Sprint(value)))
+}
+
+func (r *statsdRecorder) Info(title, text string, tags []string) error {
+	return r.add("info", title, tags)
+}
+
+func (r *statsdRecorder) Success(title, text string, tags []string) error {
+	return r.add("success", title, tags)
+}
+
+func (r *statsdRecorder) Error(title, text string, tags []string) error {
+	return r.add("error", title, tags)
+}
+
+// lines returns what was sent, sorted, since restarts finish in any order.
+func (r *statsdRecorder) lines() []string {
+	r.Lock()
+	defer r.Unlock()
+	lines := append([]string{}, r.sent...)
+	sort.Strings(lines)
+	return lines
+}
+
+func TestStatsd(t *testing.T) {
+
+	Convey("Tagging the status of a restart", t, func() {
+		So(restartStatusTag(nil, false), ShouldEqual, "status:success")
+		So(restartStatusTag(ErrRestartTimeout{}, false), ShouldEqual, "status:timeout")
+		So(restartStatusTag(ErrRestartFailed{}, false), ShouldEqual, "status:failed")
+		So(restartStatusTag(ErrRestartFailed{}, true), ShouldEqual, "status:unhealthy")
+		So(restartStatusTag(ErrRestartUnstable{}, true), ShouldEqual, "status:unstable")
+		So(restartStatusTag(ErrRestartPreempted{}, false), ShouldEqual, "status:preempted")
+	})
+
+	Convey("Sending a restart's duration", t, func() {
+		var statsd statsdRecorder
+		svr := NewSvRestarter("svc", 1, 1, time.Second)
+		svr.statsd = &statsd
+		svr.logf = func(string, ...interface{}) {}
+
+		Convey("should tag a failed restart as failed", func() {
+			svr.restarter = RestarterFunc(func(s string, t time.Duration, started chan<- struct{}) error {
+				close(started)
+				return ErrRestartFailed{Service: s, Message: "runsv not running"}
+			})
+			svr.Restart()
+			So(statsd.lines(), ShouldResemble, []string{"timer service.restart status:failed,service:svc"})
+		})
+		Convey("should tag a restart whose hook failed as such", func() {
+			svr.restarter = RestarterFunc(func(s string, t time.Duration, started chan<- struct{}) error {
+				close(started)
+				return nil
+			})
+			svr.postRestart = HookFunc(func(HookInfo) error { return fmt.Errorf("not registered") })
+			svr.Restart()
+			So(statsd.lines(), ShouldResemble, []string{"timer service.restart status:hook_failed,service:svc"})
+		})
+	})
+
+	Convey("Running a deployment with statsd", t, func() {
+		var statsd statsdRecorder
+		opts := config{CanaryRatio: Count(1), ChunkRatio: Ratio(1), Timeout: 1}.options()
+		opts.Statsd = &statsd
+		restartWith := func(err error) {
+			opts.Restarter = RestarterFunc(func(s string, t time.Duration, started chan<- struct{}) error {
+				close(started)
+				return err
+			})
+		}
+
+		Convey("should send the rollout's and each phase's results", func() {
+			restartWith(nil)
+			So(NewDeployment([]string{"a", "b", "c"}, opts).Run(context.Background()), ShouldBeNil)
+			So(statsd.lines(), ShouldResemble, []string{
+				"count canary.verdict phase:canary,verdict:passed,1",
+				"count rollout.failures status:success,0",
+				"count rollout.preempted status:success,0",
+				"count rollout.successes status:success,3",
+				"count rollout.timeouts status:success,0",
+				"count rollout.unstable status:success,0",
+				"info sv-rollout started ",
+				"success sv-rollout succeeded status:success",
+				"timer phase.duration phase:canary,status:success",
+				"timer phase.duration phase:main,status:success",
+				"timer rollout.duration status:success",
+				"timer service.restart status:success,service:a",
+				"timer service.restart status:success,service:b",
+				"timer service.restart status:success,service:c",
+			})
+		})
+		Convey("should send the canaries' failure", func() {
+			restartWith(ErrRestartFailed{Message: "runsv not running"})
+			So(NewDeployment([]string{"a", "b", "c"}, opts).Run(context.Background()), ShouldEqual, ErrTooManyFailures)
+			lines := statsd.lines()
+			So(lines, ShouldContain, "count canary.verdict phase:canary,verdict:failed,1")
+			So(lines, ShouldContain, "count rollout.failures status:failure,1")
+			So(lines, ShouldContain, "error sv-rollout failed status:failure")
+			So(lines, ShouldNotContain, "timer phase.duration phase:main,status:failure")
+		})
+	})
+}
@@ -0,0 +1,205 @@
+package rollout
+
+import (
+	"reflect"
+	"time"
+)
+
+// Restarter restarts a single service, returning nil once it's back up, or
+// else ErrRestartTimeout or ErrRestartFailed. Restart must close started as
+// soon as the restart can no longer be called off (e.g. once runit has been
+// told to restart the service): a Deployment that no longer needs the restart
+// to finish stops waiting for it then, but not before.
+//
+// Runit is the Restarter sv-rollout uses.
+type Restarter interface {
+	Restart(service string, timeout time.Duration, started chan<- struct{}) error
+}
+
+// RestarterFunc adapts an ordinary function to the Restarter interface.
+type RestarterFunc func(service string, timeout time.Duration, started chan<- struct{}) error
+
+// Restart calls f.
+func (f RestarterFunc) Restart(service string, timeout time.Duration, started chan<- struct{}) error {
+	return f(service, timeout, started)
+}
+
+// SvRestarter restarts a single service with a Restarter, then waits for it
+// to pass its health check and prove stable, reporting what happens as it
+// goes.
+type SvRestarter struct {
+	Service   string
+	svdir     string
+	nServices int
+	index     int
+	timeout   time.Duration
+	preempt   chan struct{}
+	restarter Restarter
+
+	// phase is the name of the rollout phase the service is restarted in.
+	phase string
+	// tally, if set, is updated with the result of the restart, and the
+	// resulting counts are included in the result's Event.
+	tally *tally
+
+	// healthCheck, if set, must pass before a restart counts as successful.
+	healthCheck *HealthCheck
+	// stableFor, if set, is how long the service must stay up after restarting
+	// before the restart counts as successful, as read by status.
+	stableFor time.Duration
+	status    func(svdir, service string) (superviseStatus, error)
+
+	// report, if set, is called with each Event.
+	report func(Event)
+	// preRestart and postRestart, if set, are run before and after the
+	// restart. Unless ignoreHookFailures is set, a failed hook fails it.
+	preRestart         Hook
+	postRestart        Hook
+	ignoreHookFailures bool
+
+	// statsd, if set, is sent the restart's duration.
+	statsd StatsdClient
+	// logf, if set, logs anything that isn't an Event.
+	logf func(format string, v ...interface{})
+}
+
+// NewSvRestarter instantiates a restarter for a *single* service, restarted
+// by runit in DefaultSvdir. It does not restart right away -- you must call
+// Restart for that.
+func NewSvRestarter(service string, nServices, index int, timeout time.Duration) *SvRestarter {
+	return &SvRestarter{
+		Service:   service,
+		svdir:     DefaultSvdir,
+		nServices: nServices,
+		index:     index,
+		timeout:   timeout,
+		preempt:   make(chan struct{}),
+		restarter: Runit{Svdir: DefaultSvdir},
+		status:    serviceStatus,
+	}
+}
+
+// Restart runs the pre-restart hook, has the service restarted, waits for it
+// to pass its health check and stay up if configured to, then runs the
+// post-restart hook, and reports events before and after indicating the
+// relevant status.
+func (s *SvRestarter) Restart() error {
+	s.emit(Event{Event: EventRestarting})
+	var (
+		start      = time.Now()
+		checked    bool
+		hookFailed bool
+	)
+
+	rerr := s.runHook(s.preRestart, HookPreRestart, nil)
+	if rerr != nil {
+		hookFailed = true
+	} else {
+		checked, rerr = s.restart()
+	}
+	if err := s.runHook(s.postRestart, HookPostRestart, rerr); err != nil && rerr == nil {
+		rerr = err
+		hookFailed = true
+	}
+	if rerr != nil && s.preempted() {
+		// the deployment no longer needs this restart, having counted on it
+		// timing out at worst, so however it ended it was preempted.
+		rerr = ErrRestartPreempted{Service: s.Service}
+	}
+
+	duration := time.Since(start)
+	if s.statsd != nil {
+		status := restartStatusTag(rerr, checked)
+		if hookFailed {
+			status = "status:hook_failed"
+		}
+		s.statsd.Timer("service.restart", duration, []string{status, "service:" + s.Service}, 1)
+	}
+
+	s.notifyResult(rerr, duration)
+	return rerr
+}
+
+// restart has the service restarted and waits for the result. checked is set
+// if the restart itself succeeded, but the health check or stability check
+// that followed didn't.
+func (s *SvRestarter) restart() (checked bool, rerr error) {
+	var (
+		err                  error
+		checkErr             error
+		restartDone          = make(chan struct{})
+		preemptionAcceptable = make(chan struct{})
+	)
+
+	go func() {
+		err = s.restarter.Restart(s.Service, s.timeout, preemptionAcceptable)
+		if err == nil && s.healthCheck != nil {
+			checkErr = s.healthCheck.Wait(s.Service)
+		}
+		if err == nil && checkErr == nil && s.stableFor > 0 {
+			checkErr = s.waitStable()
+		}
+		close(restartDone)
+	}()
+
+	select {
+	case <-restartDone:
+		if err != nil {
+			return false, err
+		}
+		return checkErr != nil, checkErr
+	case <-s.preempt:
+		<-preemptionAcceptable
+		return false, ErrRestartPreempted{Service: s.Service}
+	}
+}
+
+// Preempt instructs an SvRestarter that it need not hang around waiting for a
+// restart to complete; that it can just print a message about the service not
+// needing to restart successfully for the deploy to be considered a success.
+func (s *SvRestarter) Preempt() {
+	select {
+	case <-s.preempt:
+	default:
+		close(s.preempt)
+	}
+}
+
+func (s *SvRestarter) preempted() bool {
+	select {
+	case <-s.preempt:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *SvRestarter) notifyResult(result error, duration time.Duration) {
+	event := resultEvent(result)
+	if event == "" {
+		if s.logf != nil {
+			s.logf("[%d/%d] (%s) Unexpected error handled, likely a bug: %s : %s", s.index, s.nServices, s.Service, reflect.TypeOf(result).String(), result)
+		}
+		panic(result)
+	}
+	e := Event{Event: event, Duration: duration.Seconds()}
+	if result != nil {
+		e.Error = result.Error()
+	}
+	if s.tally != nil {
+		counts := s.tally.record(result)
+		e.Counts = &counts
+	}
+	s.emit(e)
+}
+
+func (s *SvRestarter) emit(e Event) {
+	e.Time = time.Now()
+	e.Phase = s.phase
+	e.Service = s.Service
+	e.Index = s.index
+	e.Services = s.nServices
+	if s.report != nil {
+		s.report(e)
+	}
+}
@@ -0,0 +1,178 @@
+package rollout
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSvRestarter(t *testing.T) {
+	var events eventRecorder
+	newSvRestarter := func(service string, restart RestarterFunc) *SvRestarter {
+		events = eventRecorder{}
+		svr := NewSvRestarter(service, 3, 2, time.Second)
+		svr.restarter = restart
+		svr.report = events.record
+		return svr
+	}
+
+	Convey("When a service restarts successfully under SvRestarter", t, func() {
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			close(a)
+			return nil
+		})
+		Convey("the results channel should get a nil and a success event should be reported", func() {
+			err := svr.Restart()
+			So(err, ShouldBeNil)
+			So(events.services(), ShouldResemble, []string{
+				"my-test-service restarting",
+				"my-test-service succeeded",
+			})
+			So(events.events[1].Index, ShouldEqual, 2)
+			So(events.events[1].Services, ShouldEqual, 3)
+		})
+	})
+
+	Convey("When a service fails to restart under SvRestarter", t, func() {
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			close(a)
+			return ErrRestartFailed{Service: s, Message: "failed"}
+		})
+		Convey("the results channel should get an error and an event should be reported", func() {
+			err := svr.Restart()
+			So(err.(ErrRestartFailed), ShouldNotBeNil)
+			So(events.services(), ShouldResemble, []string{
+				"my-test-service restarting",
+				"my-test-service failed",
+			})
+			So(events.events[1].Error, ShouldEqual, "restart failed for service 'my-test-service': failed")
+		})
+	})
+
+	Convey("When a service times out under SvRestarter", t, func() {
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			close(a)
+			return ErrRestartTimeout{Service: s}
+		})
+		Convey("the results channel should get an error and an event should be reported", func() {
+			err := svr.Restart()
+			So(err.(ErrRestartTimeout), ShouldNotBeNil)
+			So(events.services(), ShouldResemble, []string{
+				"my-test-service restarting",
+				"my-test-service timed_out",
+			})
+		})
+	})
+
+	Convey("When a service restart is preempted", t, func() {
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			close(a)
+			time.Sleep(1 * time.Second)
+			return nil
+		})
+		Convey("the results channel should get an error and a preempted event should be reported", func() {
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				svr.Preempt()
+			}()
+			err := svr.Restart()
+			So(err.(ErrRestartPreempted), ShouldNotBeNil)
+			So(events.services(), ShouldResemble, []string{
+				"my-test-service restarting",
+				"my-test-service preempted",
+			})
+		})
+	})
+
+	Convey("When a preempted restart goes on to fail", t, func() {
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			close(a)
+			return nil
+		})
+		svr.preRestart = HookFunc(func(HookInfo) error {
+			svr.Preempt()
+			return errors.New("drain failed")
+		})
+		Convey("it should still count as preempted", func() {
+			err := svr.Restart()
+			So(err, ShouldHaveSameTypeAs, ErrRestartPreempted{})
+			So(events.services(), ShouldResemble, []string{
+				"my-test-service restarting",
+				"my-test-service preempted",
+			})
+		})
+	})
+
+	Convey("When a service restarts but fails its health check", t, func() {
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			close(a)
+			return nil
+		})
+		svr.healthCheck = &HealthCheck{
+			Probe:    commandProbe{Command: "false"},
+			Timeout:  10 * time.Millisecond,
+			Interval: 10 * time.Millisecond,
+		}
+		Convey("the restart should count as failed", func() {
+			err := svr.Restart()
+			So(err.(ErrRestartFailed), ShouldNotBeNil)
+			So(events.services(), ShouldResemble, []string{
+				"my-test-service restarting",
+				"my-test-service failed",
+			})
+		})
+	})
+
+	Convey("When a service restarts and passes its health check", t, func() {
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			close(a)
+			return nil
+		})
+		svr.healthCheck = &HealthCheck{
+			Probe:    commandProbe{Command: "true"},
+			Timeout:  10 * time.Millisecond,
+			Interval: 10 * time.Millisecond,
+		}
+		Convey("the restart should succeed", func() {
+			So(svr.Restart(), ShouldBeNil)
+			So(events.services(), ShouldResemble, []string{
+				"my-test-service restarting",
+				"my-test-service succeeded",
+			})
+		})
+	})
+
+	Convey("When a service restarts but doesn't stay up", t, func() {
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			close(a)
+			return nil
+		})
+		svr.status = func(svdir, service string) (superviseStatus, error) {
+			return superviseStatus{State: stateDown}, nil
+		}
+		svr.stableFor = time.Second
+		Convey("the restart should count as unstable", func() {
+			err := svr.Restart()
+			So(err.(ErrRestartUnstable), ShouldNotBeNil)
+			So(events.services(), ShouldResemble, []string{
+				"my-test-service restarting",
+				"my-test-service unstable",
+			})
+		})
+	})
+
+	Convey("When a service is restarted with a function", t, func() {
+		var restarted string
+		svr := newSvRestarter("my-test-service", func(s string, t time.Duration, a chan<- struct{}) error {
+			restarted = s + " within " + t.String()
+			close(a)
+			return nil
+		})
+		Convey("it should be given the service and timeout", func() {
+			So(svr.Restart(), ShouldBeNil)
+			So(restarted, ShouldEqual, "my-test-service within 1s")
+		})
+	})
+}